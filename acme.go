@@ -0,0 +1,104 @@
+package beepboop
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig configures automatic certificate management
+type ACMEConfig struct {
+	Hosts        []string     // hostnames the server is allowed to request certificates for
+	Email        string       // contact email of the ACME account
+	DirectoryURL string       // ACME directory URL (defaults to Let's Encrypt)
+	CacheDir     string       // certificates are cached in DB if empty (Run fails if there is no DB either)
+	HTTPAddr     string       // address of the HTTP-01 challenge listener (defaults to ":80")
+	HTTPClient   *http.Client // HTTP client used to talk to the ACME server (like one trusting a test CA)
+}
+
+// EnableACME sets up the server to obtain and renew its TLS certificates via ACME.
+// Run serves HTTPS on srv.Addr and the HTTP-01 challenges on cfg.HTTPAddr.
+func (srv *Server) EnableACME(cfg ACMEConfig) {
+	var cache autocert.Cache = &dbCertCache{srv: srv}
+	if len(cfg.CacheDir) > 0 {
+		cache = autocert.DirCache(cfg.CacheDir)
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      cache,
+		HostPolicy: autocert.HostWhitelist(cfg.Hosts...),
+		Email:      cfg.Email,
+	}
+	if len(cfg.DirectoryURL) > 0 || cfg.HTTPClient != nil {
+		manager.Client = &acme.Client{
+			DirectoryURL: cfg.DirectoryURL,
+			HTTPClient:   cfg.HTTPClient,
+		}
+	}
+
+	srv.acmeManager = manager
+	srv.acmeCacheDir = cfg.CacheDir
+	srv.acmeHTTPAddr = cfg.HTTPAddr
+	if len(srv.acmeHTTPAddr) == 0 {
+		srv.acmeHTTPAddr = ":80"
+	}
+	// in case a proxy in front of the server forwards plain HTTP traffic to it
+	srv.mux.Handle("/.well-known/acme-challenge/", manager.HTTPHandler(nil))
+}
+
+func (srv *Server) getACMETLSConfig() (*tls.Config, error) {
+	if len(srv.acmeCacheDir) == 0 && srv.DB == nil {
+		// certificates would be issued again on every restart and hit the rate limits of the CA
+		return nil, errors.New("ACME needs a DB or CacheDir to store certificates")
+	}
+	config := srv.acmeManager.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config, nil
+}
+
+// getACMEChallengeServer returns the server that answers HTTP-01 challenges
+// and redirects every other request to HTTPS
+func (srv *Server) getACMEChallengeServer() *http.Server {
+	return &http.Server{
+		Addr:              srv.acmeHTTPAddr,
+		Handler:           srv.acmeManager.HTTPHandler(nil),
+		ReadHeaderTimeout: srv.ReadHeaderTimeout,
+		IdleTimeout:       srv.IdleTimeout,
+		ErrorLog:          srv.Logger,
+	}
+}
+
+// dbCertCache is an autocert.Cache that stores certificates in the server's DB
+type dbCertCache struct {
+	srv *Server
+}
+
+func (c *dbCertCache) Get(ctx context.Context, name string) ([]byte, error) {
+	if c.srv.DB == nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	// other errors (like a redis outage) must not trigger issuing new certificates
+	data, err := c.srv.DB.WithContext(ctx).getCert(name)
+	if err == ErrCacheMiss {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+func (c *dbCertCache) Put(ctx context.Context, name string, data []byte) error {
+	if c.srv.DB == nil {
+		return errors.New("no DB to store certificates in")
+	}
+	return c.srv.DB.WithContext(ctx).putCert(name, data)
+}
+
+func (c *dbCertCache) Delete(ctx context.Context, name string) error {
+	if c.srv.DB == nil {
+		return nil
+	}
+	return c.srv.DB.WithContext(ctx).deleteCert(name)
+}
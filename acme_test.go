@@ -0,0 +1,414 @@
+package beepboop
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeStub is a minimal pebble-like ACME (RFC 8555) server for a single order.
+// It validates HTTP-01 challenges against challengeAddr and issues certificates
+// signed by a throwaway CA. JWS signatures are not verified.
+type acmeStub struct {
+	*httptest.Server
+	t             *testing.T
+	challengeAddr string
+	caKey         *ecdsa.PrivateKey
+	caCert        *x509.Certificate
+	mtx           sync.Mutex
+	nonce         int
+	thumbprint    string
+	token         string
+	domain        string
+	orders        int
+	validated     bool
+	cert          []byte
+}
+
+type acmeStubJWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+}
+
+func newACMEStub(t *testing.T, challengeAddr string) *acmeStub {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ACME stub CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	stub := &acmeStub{
+		t:             t,
+		challengeAddr: challengeAddr,
+		caKey:         caKey,
+		caCert:        caCert,
+		token:         "stub-token",
+	}
+	stub.Server = httptest.NewServer(http.HandlerFunc(stub.handle))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (stub *acmeStub) handle(w http.ResponseWriter, r *http.Request) {
+	stub.mtx.Lock()
+	defer stub.mtx.Unlock()
+	stub.nonce++
+	w.Header().Set("Replay-Nonce", strings.Repeat("n", stub.nonce))
+
+	switch r.URL.Path {
+	case "/dir":
+		stub.respond(w, http.StatusOK, map[string]string{
+			"newNonce":   stub.URL + "/nonce",
+			"newAccount": stub.URL + "/account",
+			"newOrder":   stub.URL + "/order",
+			"revokeCert": stub.URL + "/revoke",
+			"keyChange":  stub.URL + "/key-change",
+		})
+		return
+	case "/nonce":
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payload, jwk, err := stub.parseJWS(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/account":
+		if err := stub.setThumbprint(jwk); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Location", stub.URL+"/account/1")
+		stub.respond(w, http.StatusCreated, map[string]string{"status": "valid"})
+	case "/order":
+		var req struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &req)
+		if len(req.Identifiers) == 1 {
+			stub.domain = req.Identifiers[0].Value
+		}
+		stub.orders++
+		stub.respondOrder(w, http.StatusCreated)
+	case "/order/1":
+		stub.respondOrder(w, http.StatusOK)
+	case "/authz/1":
+		stub.respond(w, http.StatusOK, map[string]interface{}{
+			"status":     stub.status("pending"),
+			"identifier": map[string]string{"type": "dns", "value": stub.domain},
+			"challenges": []interface{}{stub.challenge()},
+		})
+	case "/challenge/1":
+		stub.validate()
+		stub.respond(w, http.StatusOK, stub.challenge())
+	case "/finalize/1":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		json.Unmarshal(payload, &req)
+		if err := stub.issue(req.CSR); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stub.respondOrder(w, http.StatusOK)
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: stub.cert})
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: stub.caCert.Raw})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (stub *acmeStub) parseJWS(r *http.Request) (payload []byte, jwk json.RawMessage, err error) {
+	var jws acmeStubJWS
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, nil, err
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, nil, err
+	}
+	var header struct {
+		JWK json.RawMessage `json:"jwk"`
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, nil, err
+	}
+	payload, err = base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload, header.JWK, err
+}
+
+func (stub *acmeStub) setThumbprint(jwk json.RawMessage) error {
+	var key struct {
+		X string `json:"x"`
+		Y string `json:"y"`
+	}
+	if err := json.Unmarshal(jwk, &key); err != nil {
+		return err
+	}
+	x, err := base64.RawURLEncoding.DecodeString(key.X)
+	if err != nil {
+		return err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(key.Y)
+	if err != nil {
+		return err
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	stub.thumbprint, err = acme.JWKThumbprint(pub)
+	return err
+}
+
+// status returns the status of the authorization and its challenge
+func (stub *acmeStub) status(pending string) string {
+	if stub.validated {
+		return "valid"
+	}
+	return pending
+}
+
+func (stub *acmeStub) challenge() map[string]string {
+	return map[string]string{
+		"type":   "http-01",
+		"url":    stub.URL + "/challenge/1",
+		"token":  stub.token,
+		"status": stub.status("pending"),
+	}
+}
+
+// validate requests the key authorization from the challenge server like a CA would
+func (stub *acmeStub) validate() {
+	req, _ := http.NewRequest(http.MethodGet, "http://"+stub.challengeAddr+"/.well-known/acme-challenge/"+stub.token, nil)
+	req.Host = stub.domain
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		stub.t.Log("challenge request failed: ", err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	stub.validated = string(body) == stub.token+"."+stub.thumbprint
+}
+
+func (stub *acmeStub) issue(csrB64 string) error {
+	der, err := base64.RawURLEncoding.DecodeString(csrB64)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: stub.domain},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	stub.cert, err = x509.CreateCertificate(rand.Reader, template, stub.caCert, csr.PublicKey, stub.caKey)
+	return err
+}
+
+func (stub *acmeStub) respondOrder(w http.ResponseWriter, statusCode int) {
+	order := map[string]interface{}{
+		"status":         "pending",
+		"identifiers":    []map[string]string{{"type": "dns", "value": stub.domain}},
+		"authorizations": []string{stub.URL + "/authz/1"},
+		"finalize":       stub.URL + "/finalize/1",
+	}
+	switch {
+	case stub.cert != nil:
+		order["status"] = "valid"
+		order["certificate"] = stub.URL + "/cert/1"
+	case stub.validated:
+		order["status"] = "ready"
+	}
+	w.Header().Set("Location", stub.URL+"/order/1")
+	stub.respond(w, statusCode, order)
+}
+
+func (stub *acmeStub) respond(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+func acmeTestHello() *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:       "example.test",
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+	}
+}
+
+func TestACMEIssuesCertificate(t *testing.T) {
+	challengeListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := newACMEStub(t, challengeListener.Addr().String())
+	cfg := ACMEConfig{
+		Hosts:        []string{"example.test"},
+		DirectoryURL: stub.URL + "/dir",
+		CacheDir:     t.TempDir(),
+	}
+
+	srv := NewServer()
+	srv.EnableACME(cfg)
+	challengeSrv := srv.getACMEChallengeServer()
+	go challengeSrv.Serve(challengeListener)
+	defer challengeSrv.Close()
+
+	config, err := srv.getACMETLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := config.GetCertificate(acmeTestHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("example.test"); err != nil {
+		t.Error(err)
+	}
+	if leaf.Issuer.CommonName != "ACME stub CA" {
+		t.Errorf("issuer = %q", leaf.Issuer.CommonName)
+	}
+
+	// a restarted server loads the certificate from the cache instead of ordering a new one
+	srv = NewServer()
+	srv.EnableACME(cfg)
+	config, err = srv.getACMETLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.GetCertificate(acmeTestHello()); err != nil {
+		t.Fatal(err)
+	}
+	if stub.orders != 1 {
+		t.Errorf("%d orders, expected 1", stub.orders)
+	}
+
+	// hosts that are not allowed don't get certificates
+	hello := acmeTestHello()
+	hello.ServerName = "other.test"
+	if _, err := config.GetCertificate(hello); err == nil {
+		t.Error("expected error for a host that is not allowed")
+	}
+}
+
+func TestACMERequiresCertCache(t *testing.T) {
+	srv := NewServer()
+	srv.EnableACME(ACMEConfig{Hosts: []string{"example.test"}})
+	if _, err := srv.getACMETLSConfig(); err == nil {
+		t.Error("expected error without DB and CacheDir")
+	}
+	if err := (&dbCertCache{srv: srv}).Put(context.Background(), "example.test", []byte("cert")); err == nil {
+		t.Error("expected error from Put without DB")
+	}
+}
+
+// serveNilRedis accepts redis connections and answers every command with a nil reply
+func serveNilRedis(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					// answer after the last argument of each command
+					if strings.HasPrefix(line, "*") {
+						continue
+					}
+					if strings.HasPrefix(line, "$") {
+						if _, err := r.ReadString('\n'); err != nil {
+							return
+						}
+						if r.Buffered() == 0 {
+							conn.Write([]byte("$-1\r\n"))
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestDBCertCacheErrors(t *testing.T) {
+	srv := NewServer()
+	cache := &dbCertCache{srv: srv}
+	ctx := context.Background()
+
+	srv.DB = &DB{client: redis.NewClient(&redis.Options{Addr: serveNilRedis(t), MaxRetries: -1})}
+	if _, err := cache.Get(ctx, "example.test"); err != autocert.ErrCacheMiss {
+		t.Errorf("expected autocert.ErrCacheMiss for a missing certificate, got %v", err)
+	}
+
+	// an unreachable redis is not a cache miss (which would make autocert issue a new certificate)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+	srv.DB = &DB{client: redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})}
+	if _, err := cache.Get(ctx, "example.test"); err == nil || err == autocert.ErrCacheMiss {
+		t.Errorf("expected connection error, got %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+package beepboop
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// AssetCacheControl is the Cache-Control header of fingerprinted assets
+var AssetCacheControl = "public, max-age=31536000, immutable"
+
+const (
+	// maxPrecompressedSize is the size of the largest asset that is compressed in memory
+	// (larger ones are compressed on the fly by the server's Compression)
+	maxPrecompressedSize = 512 << 10
+	// maxContentHashSize is the size of the largest asset whose content is hashed
+	// (the hash of larger ones is derived from their size and modification time)
+	maxContentHashSize = 4 << 20
+	// maxAssetCacheSize is the total size of the compressed variants kept in memory
+	maxAssetCacheSize = 32 << 20
+	// maxAssetFiles is the number of assets whose info is cached
+	maxAssetFiles = 4096
+)
+
+// assetRegistry knows the asset serving pages of a server, the content hashes
+// of their files and their compressed variants (in an LRU cache)
+type assetRegistry struct {
+	mtx       sync.Mutex
+	sources   []assetSource
+	files     map[string]*list.Element
+	lru       *list.List
+	cacheSize int64 // total size of the cached variants
+}
+
+type assetSource struct {
+	prefix string
+	fsys   fs.FS
+}
+
+// assetFile is the cached hash and compressed variants of an asset
+type assetFile struct {
+	key         string
+	modTime     time.Time
+	size        int64
+	hash        string
+	mtx         sync.Mutex
+	variants    map[string][]byte // by content encoding
+	variantSize int64             // guarded by the registry's mutex
+}
+
+func newAssetRegistry() *assetRegistry {
+	return &assetRegistry{
+		files: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+func (reg *assetRegistry) add(prefix string, fsys fs.FS) {
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	reg.sources = append(reg.sources, assetSource{prefix: prefix, fsys: fsys})
+	// longest prefix first
+	sort.SliceStable(reg.sources, func(i, j int) bool {
+		return len(reg.sources[i].prefix) > len(reg.sources[j].prefix)
+	})
+}
+
+// find returns the fs.FS and file name of an asset URL path
+func (reg *assetRegistry) find(urlPath string) (fs.FS, string, bool) {
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	for _, src := range reg.sources {
+		if strings.HasPrefix(urlPath, src.prefix) {
+			return src.fsys, cleanAssetName(strings.TrimPrefix(urlPath, src.prefix)), true
+		}
+	}
+	return nil, "", false
+}
+
+// fingerprint returns the URL of an asset with its content hash in the file name
+// (like /static/app.3f2a9c1b04de.css) or the URL itself if it's not a known asset
+func (reg *assetRegistry) fingerprint(urlPath string) string {
+	if reg == nil {
+		return urlPath
+	}
+	fsys, name, ok := reg.find(urlPath)
+	if !ok {
+		return urlPath
+	}
+	file, err := reg.file(urlPath, fsys, name)
+	if err != nil {
+		return urlPath
+	}
+	ext := path.Ext(urlPath)
+	if len(ext) == 0 {
+		return urlPath + "?v=" + file.hash
+	}
+	return strings.TrimSuffix(urlPath, ext) + "." + file.hash + ext
+}
+
+// file returns the cached info of an asset and recalculates it if the file has changed
+func (reg *assetRegistry) file(key string, fsys fs.FS, name string) (*assetFile, error) {
+	fi, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+
+	if cached := reg.get(key); cached != nil && cached.modTime.Equal(fi.ModTime()) && cached.size == fi.Size() {
+		return cached, nil
+	}
+
+	hash, err := assetHash(fsys, name, fi)
+	if err != nil {
+		return nil, err
+	}
+	file := &assetFile{
+		key:      key,
+		modTime:  fi.ModTime(),
+		size:     fi.Size(),
+		hash:     hash,
+		variants: make(map[string][]byte),
+	}
+	reg.put(file)
+	return file, nil
+}
+
+// assetHash returns the content hash of an asset
+// (or a hash of its size and modification time if it's large)
+func assetHash(fsys fs.FS, name string, fi fs.FileInfo) (string, error) {
+	h := sha256.New()
+	if fi.Size() > maxContentHashSize {
+		h.Write([]byte(strconv.FormatInt(fi.Size(), 10) + "-" + strconv.FormatInt(fi.ModTime().UnixNano(), 10)))
+	} else {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+func (reg *assetRegistry) get(key string) *assetFile {
+	if reg == nil {
+		return nil
+	}
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	elem, ok := reg.files[key]
+	if !ok {
+		return nil
+	}
+	reg.lru.MoveToFront(elem)
+	return elem.Value.(*assetFile)
+}
+
+func (reg *assetRegistry) put(file *assetFile) {
+	if reg == nil {
+		return
+	}
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	if elem, ok := reg.files[file.key]; ok {
+		reg.remove(elem)
+	}
+	reg.files[file.key] = reg.lru.PushFront(file)
+	reg.evict()
+}
+
+// addVariant accounts for a compressed variant of a file and reports
+// whether it can be kept in memory (the file is still cached)
+func (reg *assetRegistry) addVariant(file *assetFile, size int64) bool {
+	if reg == nil {
+		return false
+	}
+	reg.mtx.Lock()
+	defer reg.mtx.Unlock()
+	elem, ok := reg.files[file.key]
+	if !ok || elem.Value != file {
+		return false
+	}
+	file.variantSize += size
+	reg.cacheSize += size
+	reg.evict()
+	return true
+}
+
+// evict removes the least recently used files until the cache fits its limits
+func (reg *assetRegistry) evict() {
+	for reg.lru.Len() > maxAssetFiles || (reg.cacheSize > maxAssetCacheSize && reg.lru.Len() > 1) {
+		reg.remove(reg.lru.Back())
+	}
+}
+
+func (reg *assetRegistry) remove(elem *list.Element) {
+	file := elem.Value.(*assetFile)
+	reg.lru.Remove(elem)
+	delete(reg.files, file.key)
+	reg.cacheSize -= file.variantSize
+	file.variantSize = 0
+}
+
+var fingerprintRegexp = regexp.MustCompile(`^(.*)\.([0-9a-f]{12})(\.[^./]+)$`)
+
+// serve serves an asset of the page at prefix with ETag, Cache-Control and
+// precompressed gzip or brotli variants
+func (reg *assetRegistry) serve(w http.ResponseWriter, r *http.Request, prefix string, fsys fs.FS, relPath string) {
+	name := cleanAssetName(relPath)
+	var requestedHash string
+	if fi, err := fs.Stat(fsys, name); err != nil {
+		if m := fingerprintRegexp.FindStringSubmatch(name); m != nil {
+			name, requestedHash = m[1]+m[3], m[2]
+		}
+	} else if fi.IsDir() {
+		name = path.Join(name, "index.html")
+	}
+
+	file, err := reg.file(prefix+name, fsys, name)
+	if err != nil {
+		if fi, _ := fs.Stat(fsys, cleanAssetName(relPath)); fi != nil && fi.IsDir() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	if len(requestedHash) > 0 && requestedHash == file.hash {
+		h.Set("Cache-Control", AssetCacheControl)
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if len(contentType) > 0 {
+		h.Set("Content-Type", contentType)
+	}
+
+	if isCompressible(contentType) {
+		h.Add("Vary", "Accept-Encoding")
+		for _, encoding := range []string{"br", "zstd", "gzip"} {
+			if encodingQuality(r, encoding) <= 0 {
+				continue
+			}
+			if content, done := reg.variant(file, fsys, name, encoding); content != nil {
+				defer done()
+				h.Set("Content-Encoding", encoding)
+				h.Set("ETag", `"`+file.hash+"-"+encoding+`"`)
+				http.ServeContent(w, r, name, file.modTime, content)
+				return
+			}
+		}
+	}
+
+	content, done := openAsset(fsys, name)
+	if content == nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer done()
+	h.Set("ETag", `"`+file.hash+`"`)
+	http.ServeContent(w, r, name, file.modTime, content)
+}
+
+// variant returns the compressed content of the asset, preferring precompressed
+// files (like app.css.br) next to it over compressing it in memory.
+// Large precompressed files are read from the file system on every request.
+func (reg *assetRegistry) variant(file *assetFile, fsys fs.FS, name, encoding string) (io.ReadSeeker, func()) {
+	file.mtx.Lock()
+	defer file.mtx.Unlock()
+	if data, ok := file.variants[encoding]; ok {
+		return readSeekerOf(data)
+	}
+
+	ext := map[string]string{"br": ".br", "zstd": ".zst", "gzip": ".gz"}[encoding]
+	var data []byte
+	if fi, err := fs.Stat(fsys, name+ext); err == nil && !fi.IsDir() {
+		if fi.Size() > maxPrecompressedSize {
+			return openAsset(fsys, name+ext)
+		}
+		data, _ = fs.ReadFile(fsys, name+ext)
+	} else if reg != nil && file.size <= maxPrecompressedSize {
+		data = compressAsset(fsys, name, encoding)
+	}
+	if reg.addVariant(file, int64(len(data))) {
+		file.variants[encoding] = data
+	}
+	return readSeekerOf(data)
+}
+
+func readSeekerOf(data []byte) (io.ReadSeeker, func()) {
+	if data == nil {
+		return nil, nil
+	}
+	return bytes.NewReader(data), func() {}
+}
+
+// openAsset opens an asset for http.ServeContent (reading it into memory if it can't seek)
+func openAsset(fsys fs.FS, name string) (io.ReadSeeker, func()) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil
+	}
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return rs, func() { f.Close() }
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil
+	}
+	return bytes.NewReader(data), func() {}
+}
+
+func compressAsset(fsys fs.FS, name, encoding string) []byte {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	switch encoding {
+	case "br":
+		zw = brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	case "zstd":
+		enc, err := newZstdWriter(&buf, zstd.SpeedBestCompression)
+		if err != nil {
+			return nil
+		}
+		zw = enc
+	case "gzip":
+		zw, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	default:
+		return nil
+	}
+	zw.Write(content)
+	if err := zw.Close(); err != nil {
+		return nil
+	}
+	// not worth it
+	if buf.Len() >= len(content) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func cleanAssetName(relPath string) string {
+	name := strings.TrimPrefix(path.Clean("/"+relPath), "/")
+	if len(name) == 0 {
+		return "."
+	}
+	return name
+}
+
+// isCompressible returns whether a content type is worth compressing
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+xml"), strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml",
+		"application/wasm", "image/svg+xml", "font/ttf", "font/otf", "image/x-icon":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package beepboop
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var testAssetCSS = strings.Repeat("body { color: #333; }\n", 100)
+
+func newAssetTestServer(pages ...*Page) *Server {
+	srv := NewServer()
+	srv.Logger = log.New(io.Discard, "", 0)
+	srv.AddPages(pages...)
+	return srv
+}
+
+func assetTestRequest(srv *Server, url string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w
+}
+
+func TestFSPageVariants(t *testing.T) {
+	srv := newAssetTestServer(FSPage("/static/", fstest.MapFS{
+		"app.css":    {Data: []byte(testAssetCSS)},
+		"app.css.br": {Data: []byte("precompressed")},
+		"logo.png":   {Data: []byte("png")},
+	}))
+
+	cases := []struct {
+		url, acceptEncoding string
+		encoding            string
+		vary                bool
+	}{
+		{"/static/app.css", "", "", true},
+		{"/static/app.css", "gzip, br", "br", true},
+		{"/static/app.css", "br;q=0, gzip", "gzip", true},
+		{"/static/app.css", "zstd", "zstd", true},
+		{"/static/logo.png", "gzip, br", "", false},
+	}
+	for _, tc := range cases {
+		w := assetTestRequest(srv, tc.url, "Accept-Encoding", tc.acceptEncoding)
+		h := w.Header()
+		name := tc.url + " " + tc.acceptEncoding
+		if w.Code != http.StatusOK || h.Get("Content-Encoding") != tc.encoding {
+			t.Errorf("%s: status %d, Content-Encoding %q, expected %q", name, w.Code, h.Get("Content-Encoding"), tc.encoding)
+			continue
+		}
+		if vary := h.Get("Vary") == "Accept-Encoding"; vary != tc.vary {
+			t.Errorf("%s: Vary = %q", name, h.Get("Vary"))
+		}
+		etag := h.Get("ETag")
+		if len(tc.encoding) > 0 && !strings.HasSuffix(etag, "-"+tc.encoding+`"`) {
+			t.Errorf("%s: ETag %s doesn't identify the variant", name, etag)
+		}
+
+		switch tc.encoding {
+		case "br":
+			if w.Body.String() != "precompressed" {
+				t.Errorf("%s: the precompressed file isn't served", name)
+			}
+		case "gzip":
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := io.ReadAll(zr); string(data) != testAssetCSS {
+				t.Errorf("%s: invalid gzip variant", name)
+			}
+		}
+
+		// revalidation with the ETag of the variant
+		w = assetTestRequest(srv, tc.url, "Accept-Encoding", tc.acceptEncoding, "If-None-Match", etag)
+		if w.Code != http.StatusNotModified || w.Body.Len() > 0 {
+			t.Errorf("%s: status %d with If-None-Match %s", name, w.Code, etag)
+		}
+	}
+}
+
+func TestFSPageFingerprint(t *testing.T) {
+	srv := newAssetTestServer(FSPage("/static/", fstest.MapFS{
+		"app.css": {Data: []byte(testAssetCSS)},
+	}))
+	url := srv.assets.fingerprint("/static/app.css")
+	if url == "/static/app.css" {
+		t.Fatal("asset URL isn't fingerprinted")
+	}
+	w := assetTestRequest(srv, url)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != AssetCacheControl {
+		t.Errorf("%s: status %d, Cache-Control %q", url, w.Code, w.Header().Get("Cache-Control"))
+	}
+	w = assetTestRequest(srv, "/static/app.000000000000.css")
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("outdated fingerprint: status %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestAssetPageDirectories(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"docs/index.html": "<p>docs</p>",
+		"empty/.keep":     "",
+	} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := newAssetTestServer(
+		FSPage("/fs/", os.DirFS(dir)),
+		StaticAssetPage("/static/", dir),
+	)
+	for url, status := range map[string]int{
+		"/fs/docs/":               http.StatusOK,
+		"/fs/empty/":              http.StatusForbidden,
+		"/static/docs/":           http.StatusForbidden,
+		"/static/empty/":          http.StatusForbidden,
+		"/static/docs/index.html": http.StatusOK,
+	} {
+		if w := assetTestRequest(srv, url); w.Code != status {
+			t.Errorf("%s: status %d, expected %d", url, w.Code, status)
+		}
+	}
+}
+
+func TestAssetRegistryBounds(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := 0; i < maxAssetFiles+10; i++ {
+		fsys[fmt.Sprintf("%d.css", i)] = &fstest.MapFile{Data: []byte(testAssetCSS)}
+	}
+	reg := newAssetRegistry()
+	for i := 0; i < maxAssetFiles+10; i++ {
+		name := fmt.Sprintf("%d.css", i)
+		file, err := reg.file(name, fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the first files are evicted later, their variants are no longer accounted for
+		if i%512 == 0 || i >= maxAssetFiles {
+			reg.variant(file, fsys, name, "gzip")
+		}
+	}
+	if n := reg.lru.Len(); n != maxAssetFiles || len(reg.files) != n {
+		t.Errorf("%d cached assets, expected %d", n, maxAssetFiles)
+	}
+	var size int64
+	for _, elem := range reg.files {
+		size += elem.Value.(*assetFile).variantSize
+	}
+	if size != reg.cacheSize || size > maxAssetCacheSize {
+		t.Errorf("cache size %d, accounted %d", size, reg.cacheSize)
+	}
+}
@@ -0,0 +1,475 @@
+package beepboop
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachePolicy configures the caching of the rendered responses of a page.
+// Only successful GET and HEAD responses without cookies are cached.
+// Pages that render session specific content should set VarySession.
+// Responses are cached per locale if the server has I18n.
+type CachePolicy struct {
+	TTL                  time.Duration             // responses are fresh for this long
+	StaleWhileRevalidate time.Duration             // stale responses are served this long while the page is re-rendered in the background
+	VaryQuery            []string                  // query parameters that are part of the cache key (all of them if nil)
+	VaryCookies          []string                  // cookies that are part of the cache key
+	VarySession          bool                      // cache responses per session
+	Tags                 []string                  // tags of the cached responses (see InvalidateTags)
+	Key                  func(*PageRequest) string // cache key of the request (URL path if nil, see InvalidatePages)
+}
+
+// PageCache stores the rendered responses of pages that have a CachePolicy
+// in DB if the server is connected to one or in memory otherwise
+type PageCache struct {
+	MaxSize      int // larger responses are not cached
+	mem          *memoryPageStore
+	mtx          sync.Mutex
+	revalidating map[string]bool
+}
+
+// NewPageCache returns a new PageCache that keeps up to size responses in memory
+// (up to size * MaxSize bytes, where MaxSize is 256KB by default)
+func NewPageCache(size int) *PageCache {
+	return &PageCache{
+		MaxSize:      256 << 10,
+		mem:          newMemoryPageStore(size),
+		revalidating: make(map[string]bool),
+	}
+}
+
+// cachedResponse is a rendered response of a page
+type cachedResponse struct {
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	ETag       string      `json:"etag"`
+	Modified   time.Time   `json:"modified"`
+	Expires    time.Time   `json:"expires"`     // fresh until
+	StaleUntil time.Time   `json:"stale_until"` // removed after
+	Tags       []string    `json:"tags"`
+}
+
+type cacheRevalidationKey struct{}
+
+// isRevalidation returns whether a request re-renders a stale cached response in the background
+func isRevalidation(r *http.Request) bool {
+	return r.Context().Value(cacheRevalidationKey{}) != nil
+}
+
+// WithCacheTags adds tags to the cached response of the view
+func WithCacheTags(tags ...string) ViewOption {
+	return func(view *View) {
+		view.cacheTags = append(view.cacheTags, tags...)
+	}
+}
+
+// WithNoCache prevents the view from being cached even if the page has a CachePolicy
+func WithNoCache() ViewOption {
+	return func(view *View) {
+		view.noCache = true
+	}
+}
+
+// InvalidatePages removes the cached responses of the given cache keys (URL paths by default)
+func (ctx *Context) InvalidatePages(keys ...string) error {
+	return ctx.PageCache.invalidate(ctx.DB, keyTags(keys)...)
+}
+
+// InvalidateTags removes the cached responses that have any of the given tags
+func (ctx *Context) InvalidateTags(tags ...string) error {
+	return ctx.PageCache.invalidate(ctx.DB, tags...)
+}
+
+// InvalidatePages removes the cached responses of the given cache keys (URL paths by default)
+func (srv *Server) InvalidatePages(keys ...string) error {
+	return srv.PageCache.invalidate(srv.DB, keyTags(keys)...)
+}
+
+// InvalidateTags removes the cached responses that have any of the given tags
+func (srv *Server) InvalidateTags(tags ...string) error {
+	return srv.PageCache.invalidate(srv.DB, tags...)
+}
+
+func keyTags(keys []string) []string {
+	tags := make([]string, len(keys))
+	for i, key := range keys {
+		tags[i] = "key:" + key
+	}
+	return tags
+}
+
+func (policy *CachePolicy) allows(pr *PageRequest) bool {
+	if policy == nil || policy.TTL <= 0 || pr.Context.PageCache == nil {
+		return false
+	}
+	switch pr.Request.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	}
+	return false
+}
+
+func (policy *CachePolicy) baseKey(pr *PageRequest) string {
+	if policy.Key != nil {
+		return policy.Key(pr)
+	}
+	return pr.Request.URL.Path
+}
+
+// key returns the cache key of the request, which is the base key and the hash
+// of everything else the response varies by
+func (policy *CachePolicy) key(pr *PageRequest) string {
+	r := pr.Request
+	h := sha256.New()
+	add := func(parts ...string) {
+		for _, part := range parts {
+			h.Write([]byte(part))
+			h.Write([]byte{0})
+		}
+	}
+
+	query := r.URL.Query()
+	if policy.VaryQuery != nil {
+		varied := make(url.Values)
+		for _, key := range policy.VaryQuery {
+			if values, ok := query[key]; ok {
+				varied[key] = values
+			}
+		}
+		query = varied
+	}
+	add("query", query.Encode())
+	for _, name := range policy.VaryCookies {
+		if c, _ := r.Cookie(name); c != nil {
+			add("cookie", name, c.Value)
+		}
+	}
+	if policy.VarySession {
+		if c, _ := r.Cookie("session"); c != nil {
+			add("session", c.Value)
+		}
+	}
+	// the layout and fragments depend on these
+	add("locale", pr.Localizer().Locale())
+	add("api", strconv.FormatBool(pr.IsAPI))
+	add("color-scheme", GetColorScheme(r))
+	if pr.IsFragment() {
+		add("fragment", pr.FragmentTarget())
+	}
+	return policy.baseKey(pr) + "#" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (policy *CachePolicy) tags(pr *PageRequest, view *View) []string {
+	tags := []string{"key:" + policy.baseKey(pr)}
+	tags = append(tags, policy.Tags...)
+	return append(tags, view.cacheTags...)
+}
+
+// get returns a cached response and whether it's still fresh
+func (c *PageCache) get(db *DB, key string) (*cachedResponse, bool) {
+	var resp *cachedResponse
+	if db != nil {
+		resp, _ = db.getCachedPage(key)
+	} else {
+		resp = c.mem.get(key)
+	}
+	if resp == nil || time.Now().After(resp.StaleUntil) {
+		return nil, false
+	}
+	return resp, time.Now().Before(resp.Expires)
+}
+
+func (c *PageCache) put(db *DB, key string, resp *cachedResponse) error {
+	if db != nil {
+		return db.putCachedPage(key, resp, time.Until(resp.StaleUntil))
+	}
+	c.mem.put(key, resp)
+	return nil
+}
+
+func (c *PageCache) invalidate(db *DB, tags ...string) error {
+	if c == nil {
+		return nil
+	}
+	if db != nil {
+		return db.invalidatePageTags(tags...)
+	}
+	c.mem.invalidate(tags...)
+	return nil
+}
+
+// startRevalidation returns false if the response is already being revalidated
+func (c *PageCache) startRevalidation(key string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.revalidating[key] {
+		return false
+	}
+	c.revalidating[key] = true
+	return true
+}
+
+func (c *PageCache) endRevalidation(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.revalidating, key)
+}
+
+// serveCached serves a cached response of the page and re-renders it in the background if it's stale
+func (page *Page) serveCached(handler http.Handler, w http.ResponseWriter, pr *PageRequest, key string) bool {
+	if isRevalidation(pr.Request) {
+		return false
+	}
+	ctx := pr.Context
+	resp, fresh := ctx.PageCache.get(ctx.DB, key)
+	if resp == nil {
+		return false
+	}
+	// new sessions and CSRF tokens still need their cookies
+	for _, cookie := range pr.sessionCookies() {
+		http.SetCookie(w, cookie)
+	}
+	w, finish := ctx.Compression.wrap(w, pr.Request)
+	defer finish()
+	if fresh {
+		resp.serve(w, pr.Request, "HIT")
+		return true
+	}
+	if ctx.PageCache.startRevalidation(key) {
+		r := pr.Request.Clone(context.WithValue(detachContext(pr.Request.Context()), cacheRevalidationKey{}, true))
+		for _, header := range []string{"If-None-Match", "If-Modified-Since", "Range", "Accept-Encoding"} {
+			r.Header.Del(header)
+		}
+		go func() {
+			defer ctx.PageCache.endRevalidation(key)
+			handler.ServeHTTP(newDiscardResponseWriter(), r)
+		}()
+	}
+	resp.serve(w, pr.Request, "STALE")
+	return true
+}
+
+// renderCached renders the view and caches the response if it's cacheable
+func (page *Page) renderCached(w http.ResponseWriter, pr *PageRequest, view *View, key string) {
+	ctx := pr.Context
+	rec := &cacheRecorder{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+		maxSize:        ctx.PageCache.MaxSize,
+	}
+	pr.renderView(rec, view)
+	if rec.passthrough {
+		return
+	}
+
+	h := w.Header()
+	cacheable := rec.statusCode == http.StatusOK && !view.noCache && !pr.noCache &&
+		len(h.Values("Set-Cookie")) == 0 && !strings.Contains(h.Get("Cache-Control"), "no-store")
+	if !cacheable {
+		rec.flush()
+		return
+	}
+
+	if len(h.Get("Cache-Control")) == 0 {
+		if page.Cache.VarySession || len(page.Cache.VaryCookies) > 0 {
+			h.Set("Cache-Control", "private, no-cache")
+		} else {
+			h.Set("Cache-Control", "no-cache")
+		}
+	}
+	if len(h.Get("Content-Type")) == 0 {
+		h.Set("Content-Type", http.DetectContentType(rec.buf.Bytes()))
+	}
+	header := h.Clone()
+	for _, key := range []string{"Content-Length", "Date", "X-Cache", "Age"} {
+		header.Del(key)
+	}
+	body := rec.buf.Bytes()
+	hash := sha256.Sum256(body)
+	now := time.Now()
+	resp := &cachedResponse{
+		Header:     header,
+		Body:       append([]byte(nil), body...),
+		ETag:       `"` + hex.EncodeToString(hash[:])[:16] + `"`,
+		Modified:   now.UTC().Truncate(time.Second),
+		Expires:    now.Add(page.Cache.TTL),
+		StaleUntil: now.Add(page.Cache.TTL + page.Cache.StaleWhileRevalidate),
+		Tags:       page.Cache.tags(pr, view),
+	}
+	if err := ctx.PageCache.put(ctx.DB, key, resp); err != nil {
+		pr.Log("failed to cache response: ", err)
+	}
+	resp.serve(w, pr.Request, "MISS")
+}
+
+// serve writes the cached response or 304 Not Modified if the client has it already
+func (resp *cachedResponse) serve(w http.ResponseWriter, r *http.Request, status string) {
+	h := w.Header()
+	for key, values := range resp.Header {
+		h[key] = values
+	}
+	h.Set("ETag", resp.ETag)
+	h.Set("X-Cache", status)
+	if age := time.Since(resp.Modified); age >= time.Second {
+		h.Set("Age", strconv.Itoa(int(age.Seconds())))
+	}
+	http.ServeContent(w, r, "", resp.Modified, bytes.NewReader(resp.Body))
+}
+
+// cacheRecorder buffers the response until it's rendered or turns out to be
+// too large or streamed, in which case it's written to the client without caching
+type cacheRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	buf         bytes.Buffer
+	maxSize     int
+	passthrough bool
+}
+
+func (rec *cacheRecorder) WriteHeader(statusCode int) {
+	if !rec.passthrough && !rec.wroteHeader {
+		rec.wroteHeader = true
+		rec.statusCode = statusCode
+	}
+}
+
+func (rec *cacheRecorder) Write(p []byte) (int, error) {
+	if rec.passthrough {
+		return rec.ResponseWriter.Write(p)
+	}
+	if rec.buf.Len()+len(p) > rec.maxSize {
+		rec.flush()
+		return rec.ResponseWriter.Write(p)
+	}
+	return rec.buf.Write(p)
+}
+
+// Flush stops recording and sends the response so far
+func (rec *cacheRecorder) Flush() {
+	rec.flush()
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter (used by http.ResponseController)
+func (rec *cacheRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *cacheRecorder) flush() {
+	if rec.passthrough {
+		return
+	}
+	rec.passthrough = true
+	rec.ResponseWriter.WriteHeader(rec.statusCode)
+	if rec.buf.Len() > 0 {
+		rec.ResponseWriter.Write(rec.buf.Bytes())
+	}
+	rec.buf = bytes.Buffer{}
+}
+
+// discardResponseWriter is the ResponseWriter of background revalidations
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func newDiscardResponseWriter() *discardResponseWriter {
+	return &discardResponseWriter{header: make(http.Header)}
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+// memoryPageStore is an LRU cache of responses
+type memoryPageStore struct {
+	mtx     sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]bool
+}
+
+type memoryPageEntry struct {
+	key  string
+	resp *cachedResponse
+}
+
+func newMemoryPageStore(size int) *memoryPageStore {
+	return &memoryPageStore{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		tags:    make(map[string]map[string]bool),
+	}
+}
+
+func (s *memoryPageStore) get(key string) *cachedResponse {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*memoryPageEntry)
+	if time.Now().After(entry.resp.StaleUntil) {
+		s.remove(elem)
+		return nil
+	}
+	s.lru.MoveToFront(elem)
+	return entry.resp
+}
+
+func (s *memoryPageStore) put(key string, resp *cachedResponse) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+	s.entries[key] = s.lru.PushFront(&memoryPageEntry{key: key, resp: resp})
+	for _, tag := range resp.Tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]bool)
+		}
+		s.tags[tag][key] = true
+	}
+	for s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *memoryPageStore) invalidate(tags ...string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			if elem, ok := s.entries[key]; ok {
+				s.remove(elem)
+			}
+		}
+		delete(s.tags, tag)
+	}
+}
+
+func (s *memoryPageStore) remove(elem *list.Element) {
+	entry := elem.Value.(*memoryPageEntry)
+	s.lru.Remove(elem)
+	delete(s.entries, entry.key)
+	for _, tag := range entry.resp.Tags {
+		delete(s.tags[tag], entry.key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package beepboop
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCacheTestServer() *Server {
+	srv := NewServer()
+	srv.Logger = log.New(io.Discard, "", 0)
+	srv.I18n = NewI18n("en")
+	srv.I18n.Catalog("hu").Set("Hello", "Szia")
+	srv.AddMiddleware(func(pr *PageRequest) *View {
+		if pref := pr.Request.Header.Get("X-Preference"); len(pref) > 0 {
+			pr.Session().SetPreference("test", pref)
+		}
+		return nil
+	})
+	srv.AddPages(&Page{
+		Path:            "/cached",
+		ContentTemplate: `{{T "Hello"}}`,
+		Cache:           &CachePolicy{TTL: time.Minute},
+	})
+	return srv
+}
+
+func cacheTestRequest(srv *Server, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/cached", nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w
+}
+
+func TestPageCacheVariesByLocale(t *testing.T) {
+	srv := newCacheTestServer()
+	for _, lang := range []string{"en", "hu", "en", "hu"} {
+		cacheTestRequest(srv, "Accept-Language", lang)
+	}
+	w := cacheTestRequest(srv, "Accept-Language", "hu")
+	if cache := w.Header().Get("X-Cache"); cache != "HIT" {
+		t.Errorf("X-Cache = %q", cache)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "Szia") {
+		t.Errorf("cached response in the wrong locale: %s", body)
+	}
+	w = cacheTestRequest(srv, "Accept-Language", "en")
+	if body := w.Body.String(); !strings.Contains(body, "Hello") || strings.Contains(body, "Szia") {
+		t.Errorf("cached response in the wrong locale: %s", body)
+	}
+}
+
+func TestPageCacheHitSetsSessionCookies(t *testing.T) {
+	srv := newCacheTestServer()
+	if w := cacheTestRequest(srv); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("X-Cache = %q", w.Header().Get("X-Cache"))
+	}
+	w := cacheTestRequest(srv, "X-Preference", "value")
+	if cache := w.Header().Get("X-Cache"); cache != "HIT" {
+		t.Errorf("X-Cache = %q", cache)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "preference-test" || cookies[0].Value != "value" {
+		t.Errorf("session cookies of the cache hit: %v", cookies)
+	}
+}
+
+func TestPageCacheStaleHitRevalidatesOnce(t *testing.T) {
+	var logs bytes.Buffer
+	srv := NewServer()
+	srv.Logger = log.New(&logs, "", 0)
+	srv.LogPipeline = nil
+	var renders int32
+	revalidating := make(chan struct{})
+	release := make(chan struct{})
+	srv.AddPages(&Page{
+		Path:            "/stale",
+		ContentTemplate: `stale`,
+		Cache:           &CachePolicy{TTL: time.Millisecond, StaleWhileRevalidate: time.Minute},
+		Handler: func(pr *PageRequest) *View {
+			if atomic.AddInt32(&renders, 1) == 2 {
+				close(revalidating)
+				<-release
+			}
+			return nil
+		},
+	})
+	request := func() string {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stale", nil))
+		return w.Header().Get("X-Cache")
+	}
+
+	if cache := request(); cache != "MISS" {
+		t.Fatalf("X-Cache = %q", cache)
+	}
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		if cache := request(); cache != "STALE" {
+			t.Errorf("X-Cache = %q", cache)
+		}
+	}
+	<-revalidating
+	close(release)
+	for i := 0; ; i++ {
+		srv.PageCache.mtx.Lock()
+		done := len(srv.PageCache.revalidating) == 0
+		srv.PageCache.mtx.Unlock()
+		if done {
+			break
+		}
+		if i == 100 {
+			t.Fatal("revalidation didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := atomic.LoadInt32(&renders); n != 2 {
+		t.Errorf("%d renders, expected 2", n)
+	}
+	// only the requests of the client are logged
+	if n := strings.Count(logs.String(), "GET /stale"); n != 11 || strings.Contains(logs.String(), "cache revalidation") {
+		t.Errorf("%d logged requests, expected 11:\n%s", n, logs.String())
+	}
+}
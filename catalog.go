@@ -0,0 +1,280 @@
+package beepboop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Plural categories as defined by CLDR
+const (
+	PluralZero  = "zero"
+	PluralOne   = "one"
+	PluralTwo   = "two"
+	PluralFew   = "few"
+	PluralMany  = "many"
+	PluralOther = "other"
+)
+
+// PluralRule selects the plural form of a message
+type PluralRule struct {
+	Categories []string        // plural categories in the order of PO msgstr[n] forms
+	Form       func(n int) int // index of the plural form of n
+}
+
+var pluralOneOther = PluralRule{
+	Categories: []string{PluralOne, PluralOther},
+	Form: func(n int) int {
+		if n == 1 {
+			return 0
+		}
+		return 1
+	},
+}
+
+var pluralFrench = PluralRule{
+	Categories: []string{PluralOne, PluralOther},
+	Form: func(n int) int {
+		if n == 0 || n == 1 {
+			return 0
+		}
+		return 1
+	},
+}
+
+var pluralOther = PluralRule{
+	Categories: []string{PluralOther},
+	Form:       func(n int) int { return 0 },
+}
+
+var pluralSlavic = PluralRule{
+	Categories: []string{PluralOne, PluralFew, PluralMany},
+	Form: func(n int) int {
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return 0
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return 1
+		default:
+			return 2
+		}
+	},
+}
+
+var pluralPolish = PluralRule{
+	Categories: []string{PluralOne, PluralFew, PluralMany},
+	Form: func(n int) int {
+		switch {
+		case n == 1:
+			return 0
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return 1
+		default:
+			return 2
+		}
+	},
+}
+
+var pluralCzech = PluralRule{
+	Categories: []string{PluralOne, PluralFew, PluralOther},
+	Form: func(n int) int {
+		switch {
+		case n == 1:
+			return 0
+		case n >= 2 && n <= 4:
+			return 1
+		default:
+			return 2
+		}
+	},
+}
+
+// PluralRules contains the plural rules of languages that don't use the English one/other rule
+var PluralRules = map[string]PluralRule{
+	"fr": pluralFrench,
+	"pt": pluralFrench,
+	"ja": pluralOther,
+	"ko": pluralOther,
+	"zh": pluralOther,
+	"vi": pluralOther,
+	"th": pluralOther,
+	"id": pluralOther,
+	"ru": pluralSlavic,
+	"uk": pluralSlavic,
+	"be": pluralSlavic,
+	"sr": pluralSlavic,
+	"hr": pluralSlavic,
+	"bs": pluralSlavic,
+	"pl": pluralPolish,
+	"cs": pluralCzech,
+	"sk": pluralCzech,
+}
+
+func getPluralRule(locale string) PluralRule {
+	if rule, ok := PluralRules[baseLanguage(locale)]; ok {
+		return rule
+	}
+	return pluralOneOther
+}
+
+// Catalog contains the translated messages of a locale
+type Catalog struct {
+	Locale   string
+	Plural   PluralRule
+	Messages map[string][]string // translations by msgid (plural forms are in the order of Plural.Categories)
+}
+
+// messageKey returns the key of a message in Catalog.Messages
+// (context + "\x04" + msgid like in gettext if the message has a context)
+func messageKey(context, msgid string) string {
+	if len(context) == 0 {
+		return msgid
+	}
+	return context + "\x04" + msgid
+}
+
+// NewCatalog returns a new empty Catalog
+func NewCatalog(locale string) *Catalog {
+	return &Catalog{
+		Locale:   locale,
+		Plural:   getPluralRule(locale),
+		Messages: make(map[string][]string),
+	}
+}
+
+// Set sets the translation (and optional plural forms) of a message
+func (c *Catalog) Set(msgid string, forms ...string) {
+	c.Messages[msgid] = forms
+}
+
+// SetWithContext sets the translation of a message in a context (msgctxt in PO files)
+// which tells apart messages with the same msgid
+func (c *Catalog) SetWithContext(context, msgid string, forms ...string) {
+	c.Set(messageKey(context, msgid), forms...)
+}
+
+func (c *Catalog) lookup(msgid string, n int) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	forms := c.Messages[msgid]
+	if len(forms) == 0 {
+		return "", false
+	}
+	if form := c.Plural.Form(n); form < len(forms) && len(forms[form]) > 0 {
+		return forms[form], true
+	}
+	return forms[len(forms)-1], len(forms[len(forms)-1]) > 0
+}
+
+// LoadJSON loads messages from a JSON object where values are either translations,
+// arrays of plural forms or objects of plural categories (like {"one": "...", "other": "..."})
+func (c *Catalog) LoadJSON(data []byte) error {
+	var messages map[string]interface{}
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return err
+	}
+	for msgid, value := range messages {
+		switch value := value.(type) {
+		case string:
+			c.Set(msgid, value)
+		case []interface{}:
+			forms := make([]string, len(value))
+			for i, form := range value {
+				forms[i], _ = form.(string)
+			}
+			c.Set(msgid, forms...)
+		case map[string]interface{}:
+			forms := make([]string, len(c.Plural.Categories))
+			for i, category := range c.Plural.Categories {
+				forms[i], _ = value[category].(string)
+			}
+			c.Set(msgid, forms...)
+		default:
+			return fmt.Errorf("invalid translation of %q", msgid)
+		}
+	}
+	return nil
+}
+
+// LoadPO loads messages from a gettext PO file
+func (c *Catalog) LoadPO(data []byte) error {
+	var msgctxt, msgid string
+	var forms []string
+	var current *string
+	lineNum := 0
+
+	flush := func() {
+		if len(msgid) > 0 && len(forms) > 0 {
+			c.SetWithContext(msgctxt, msgid, forms...)
+		}
+		msgctxt, msgid, forms, current = "", "", nil, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		keyword := line
+		value := ""
+		if i := strings.IndexByte(line, ' '); i != -1 {
+			keyword, value = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		if strings.HasPrefix(line, `"`) {
+			keyword, value = "", line
+		}
+		str, err := strconv.Unquote(value)
+		if err != nil {
+			return fmt.Errorf("PO line %d: invalid string %s", lineNum, value)
+		}
+
+		switch {
+		case keyword == "":
+			if current == nil {
+				return fmt.Errorf("PO line %d: unexpected string", lineNum)
+			}
+			*current += str
+		case keyword == "msgctxt":
+			flush()
+			msgctxt = str
+			current = &msgctxt
+		case keyword == "msgid":
+			if len(forms) > 0 {
+				flush()
+			}
+			msgid = str
+			current = &msgid
+		case keyword == "msgid_plural":
+			current = new(string)
+		case keyword == "msgstr":
+			forms = append(forms, str)
+			current = &forms[len(forms)-1]
+		case strings.HasPrefix(keyword, "msgstr["):
+			forms = append(forms, str)
+			current = &forms[len(forms)-1]
+		default:
+			return fmt.Errorf("PO line %d: unknown keyword %s", lineNum, keyword)
+		}
+	}
+	flush()
+	return scanner.Err()
+}
+
+func baseLanguage(locale string) string {
+	locale = normalizeLocale(locale)
+	if i := strings.IndexByte(locale, '-'); i != -1 {
+		return locale[:i]
+	}
+	return locale
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
@@ -0,0 +1,62 @@
+package beepboop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadPOContext(t *testing.T) {
+	c := NewCatalog("hu")
+	err := c.LoadPO([]byte(`
+msgid ""
+msgstr ""
+"Language: hu\n"
+
+msgid "Open"
+msgstr "Megnyitás"
+
+msgctxt "door"
+msgid "Open"
+msgstr "Nyitva"
+
+msgctxt "menu"
+msgid "%d file"
+msgid_plural "%d files"
+msgstr[0] "%d fájl"
+msgstr[1] "%d fájl"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Localizer{locale: "hu", catalog: c}
+	if msg := l.T("Open"); msg != "Megnyitás" {
+		t.Errorf("T = %q", msg)
+	}
+	if msg := l.TC("door", "Open"); msg != "Nyitva" {
+		t.Errorf("TC = %q", msg)
+	}
+	if msg := l.TC("window", "Open"); msg != "Open" {
+		t.Errorf("TC with unknown context = %q", msg)
+	}
+	if msg := l.NC("menu", "%d file", "%d files", 3); msg != "3 fájl" {
+		t.Errorf("NC = %q", msg)
+	}
+	if msg := l.N("%d file", "%d files", 3); msg != "3 files" {
+		t.Errorf("N without context = %q", msg)
+	}
+}
+
+func TestTimeElapsed(t *testing.T) {
+	now := time.Now()
+	for then, expected := range map[time.Time]string{
+		now.Add(-5 * time.Second):      "just now",
+		now.Add(-2 * time.Minute):      "2 minutes ago",
+		now.Add(-25 * time.Hour):       "1 day ago",
+		now.Add(-400 * 24 * time.Hour): "1 year ago",
+		now.Add(time.Hour):             "just now",
+	} {
+		if text := TimeElapsed(now, then); text != expected {
+			t.Errorf("TimeElapsed(%v) = %q, expected %q", now.Sub(then), text, expected)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package beepboop
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultClientIPHeaders are the forwarding headers checked by resolvers that aren't given any.
+// Other headers (like Forwarded or X-Real-IP) have to be opted into, because trusted proxies
+// that don't set them pass them through from clients unchanged.
+var DefaultClientIPHeaders = []string{"X-Forwarded-For"}
+
+// defaultClientIPResolver only trusts proxies running on the same host
+var defaultClientIPResolver, _ = NewClientIPResolver([]string{"127.0.0.0/8", "::1"})
+
+// ClientIPResolver resolves the IP address of the client behind trusted reverse proxies.
+// Forwarding headers are only taken into account if the request comes from a trusted proxy
+// (peers of unix sockets are local proxies and always trusted).
+type ClientIPResolver struct {
+	TrustedProxies []*net.IPNet
+	Headers        []string
+}
+
+// NewClientIPResolver returns a new ClientIPResolver that trusts the given proxy CIDRs
+// (or single IPs) and checks the given headers in order of precedence
+// (only list headers that the trusted proxies overwrite or append to)
+func NewClientIPResolver(trustedProxies []string, headers ...string) (*ClientIPResolver, error) {
+	nets, err := parseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) == 0 {
+		headers = DefaultClientIPHeaders
+	}
+	return &ClientIPResolver{
+		TrustedProxies: nets,
+		Headers:        headers,
+	}, nil
+}
+
+// ClientIP returns the canonical IP address of the client
+func (res *ClientIPResolver) ClientIP(r *http.Request) string {
+	remoteIP := parseIP(r.RemoteAddr)
+	if !res.trustsRemoteAddr(r.RemoteAddr) {
+		return remoteAddrHost(r.RemoteAddr, remoteIP)
+	}
+
+	for _, header := range res.Headers {
+		var chain []net.IP
+		switch http.CanonicalHeaderKey(header) {
+		case "Forwarded":
+			chain = parseForwarded(r.Header.Values("Forwarded"))
+		case "X-Forwarded-For":
+			chain = parseIPList(r.Header.Values("X-Forwarded-For"))
+		default:
+			chain = parseIPList(r.Header.Values(header))
+		}
+		if len(chain) == 0 {
+			continue
+		}
+		// walk from the closest hop and return the first address that isn't a trusted proxy
+		for i := len(chain) - 1; i >= 0; i-- {
+			if !res.isTrusted(chain[i]) {
+				return chain[i].String()
+			}
+		}
+		return chain[0].String()
+	}
+
+	return remoteAddrHost(r.RemoteAddr, remoteIP)
+}
+
+// trustsRemoteAddr returns whether the peer of a request is a trusted proxy
+// (unix socket peers have no IP address and can only be local proxies)
+func (res *ClientIPResolver) trustsRemoteAddr(remoteAddr string) bool {
+	if res == nil {
+		return false
+	}
+	ip := parseIP(remoteAddr)
+	return ip == nil || res.isTrusted(ip)
+}
+
+func remoteAddrHost(remoteAddr string, remoteIP net.IP) string {
+	if remoteIP == nil {
+		host, _, _ := net.SplitHostPort(remoteAddr)
+		return host
+	}
+	return remoteIP.String()
+}
+
+func (res *ClientIPResolver) isTrusted(ip net.IP) bool {
+	for _, n := range res.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", cidr)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// parseIP parses an IP address with an optional port, brackets or IPv6 zone
+func parseIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if i := strings.IndexByte(addr, '%'); i != -1 {
+		addr = addr[:i]
+	}
+	return net.ParseIP(addr)
+}
+
+func parseIPList(values []string) []net.IP {
+	var ips []net.IP
+	for _, value := range values {
+		for _, addr := range strings.Split(value, ",") {
+			if ip := parseIP(addr); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// parseForwarded returns the 'for' addresses of RFC 7239 Forwarded headers
+func parseForwarded(values []string) []net.IP {
+	var ips []net.IP
+	for _, value := range values {
+		for _, elem := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "for") {
+					continue
+				}
+				if ip := parseIP(strings.Trim(kv[1], `"`)); ip != nil {
+					ips = append(ips, ip)
+				}
+			}
+		}
+	}
+	return ips
+}
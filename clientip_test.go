@@ -0,0 +1,48 @@
+package beepboop
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	res, err := NewClientIPResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	optIn, err := NewClientIPResolver([]string{"10.0.0.0/8"}, "Forwarded", "X-Forwarded-For")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		res        *ClientIPResolver
+		remoteAddr string
+		header     map[string]string
+		expected   string
+	}{
+		{"direct", res, "1.1.1.1:1234", nil, "1.1.1.1"},
+		{"untrusted peer", res, "1.1.1.1:1234", map[string]string{"X-Forwarded-For": "2.2.2.2"}, "1.1.1.1"},
+		{"trusted proxy", res, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "2.2.2.2"}, "2.2.2.2"},
+		{"proxy chain", res, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "3.3.3.3, 2.2.2.2, 10.0.0.2"}, "2.2.2.2"},
+		{
+			"spoofed Forwarded", res, "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=6.6.6.6", "X-Forwarded-For": "6.6.6.6, 2.2.2.2"},
+			"2.2.2.2",
+		},
+		{"opted into Forwarded", optIn, "10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8::1]:80"`}, "2001:db8::1"},
+		{"unix socket", res, "@", map[string]string{"X-Forwarded-For": "2.2.2.2"}, "2.2.2.2"},
+		{"unix socket without header", res, "", nil, ""},
+		{"nil resolver", nil, "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "2.2.2.2"}, "10.0.0.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remoteAddr
+		for key, value := range tc.header {
+			r.Header.Set(key, value)
+		}
+		if ip := tc.res.ClientIP(r); ip != tc.expected {
+			t.Errorf("%s: got %q, expected %q", tc.name, ip, tc.expected)
+		}
+	}
+}
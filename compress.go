@@ -0,0 +1,330 @@
+package beepboop
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressor creates compressing writers of a content encoding.
+// Writers that have a Reset(io.Writer) method are reused.
+type Compressor struct {
+	Encoding  string
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	pool      sync.Pool
+}
+
+// GzipCompressor returns a Compressor for the gzip content encoding
+func GzipCompressor(level int) *Compressor {
+	return &Compressor{
+		Encoding: "gzip",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+	}
+}
+
+// DeflateCompressor returns a Compressor for the deflate content encoding
+func DeflateCompressor(level int) *Compressor {
+	return &Compressor{
+		Encoding: "deflate",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		},
+	}
+}
+
+// BrotliCompressor returns a Compressor for the br content encoding
+func BrotliCompressor(level int) *Compressor {
+	return &Compressor{
+		Encoding: "br",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return brotli.NewWriterLevel(w, level), nil
+		},
+	}
+}
+
+// zstdWindowSize is the largest window browsers decode in the zstd content encoding (RFC 8878)
+const zstdWindowSize = 8 << 20
+
+// ZstdCompressor returns a Compressor for the zstd content encoding
+// (level is a zstd compression level like 3)
+func ZstdCompressor(level int) *Compressor {
+	return &Compressor{
+		Encoding: "zstd",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return newZstdWriter(w, zstd.EncoderLevelFromZstd(level))
+		},
+	}
+}
+
+func newZstdWriter(w io.Writer, level zstd.EncoderLevel) (*zstd.Encoder, error) {
+	return zstd.NewWriter(w,
+		zstd.WithEncoderLevel(level),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(zstdWindowSize))
+}
+
+type writerResetter interface {
+	Reset(w io.Writer)
+}
+
+func (c *Compressor) getWriter(w io.Writer) (io.WriteCloser, error) {
+	if zw, ok := c.pool.Get().(io.WriteCloser); ok {
+		zw.(writerResetter).Reset(w)
+		return zw, nil
+	}
+	return c.NewWriter(w)
+}
+
+func (c *Compressor) putWriter(zw io.WriteCloser) {
+	if _, ok := zw.(writerResetter); ok {
+		c.pool.Put(zw)
+	}
+}
+
+// Compression configures the compression of responses
+type Compression struct {
+	Compressors  []*Compressor // in order of preference
+	MinSize      int           // smaller responses are not compressed
+	ContentTypes []string      // compressed media types (like "text/*"), text, JS, JSON, XML, etc if empty
+}
+
+// NewCompression returns the default brotli, zstd, gzip and deflate compression of responses
+// larger than 1KB
+func NewCompression() *Compression {
+	return &Compression{
+		Compressors: []*Compressor{
+			BrotliCompressor(4),
+			ZstdCompressor(3),
+			GzipCompressor(gzip.DefaultCompression),
+			DeflateCompressor(flate.DefaultCompression),
+		},
+		MinSize: 1024,
+	}
+}
+
+func (c *Compression) isAllowed(contentType string) bool {
+	if len(c.ContentTypes) == 0 {
+		return isCompressible(contentType)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, allowed := range c.ContentTypes {
+		if strings.HasSuffix(allowed, "*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate returns the preferred compressor accepted by the request (or nil)
+func (c *Compression) negotiate(r *http.Request) *Compressor {
+	if c == nil {
+		return nil
+	}
+	var best *Compressor
+	bestQ := 0.0
+	for _, comp := range c.Compressors {
+		if q := encodingQuality(r, comp.Encoding); q > bestQ {
+			best, bestQ = comp, q
+		}
+	}
+	return best
+}
+
+// encodingQuality returns the q value of an encoding in the Accept-Encoding header of the request
+func encodingQuality(r *http.Request, encoding string) float64 {
+	wildcard := 0.0
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			fields := strings.Split(part, ";")
+			name := strings.TrimSpace(fields[0])
+			q := 1.0
+			for _, param := range fields[1:] {
+				if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+					q, _ = strconv.ParseFloat(param[2:], 64)
+				}
+			}
+			if strings.EqualFold(name, encoding) {
+				return q
+			}
+			if name == "*" {
+				wildcard = q
+			}
+		}
+	}
+	return wildcard
+}
+
+// wrap returns a ResponseWriter that compresses the response if it's worth it
+// and a function that finishes the response
+func (c *Compression) wrap(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	comp := c.negotiate(r)
+	if comp == nil || r.Method == http.MethodHead {
+		return w, func() {}
+	}
+	cw := &compressWriter{
+		ResponseWriter: w,
+		compression:    c,
+		compressor:     comp,
+		statusCode:     http.StatusOK,
+	}
+	return cw, cw.finish
+}
+
+// compressWriter buffers the beginning of the response until it can decide
+// whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	compression *Compression
+	compressor  *Compressor
+	statusCode  int
+	wroteHeader bool // WriteHeader was called by the handler
+	decided     bool
+	buf         []byte
+	zw          io.WriteCloser
+	hijacked    bool
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.statusCode = statusCode
+	if statusCode < 200 || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified ||
+		statusCode == http.StatusPartialContent {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.zw != nil {
+			return cw.zw.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.compression.MinSize {
+		cw.decide(true)
+	}
+	return len(p), nil
+}
+
+// decide writes the header and the buffered content (compressed if possible)
+func (cw *compressWriter) decide(sizeOK bool) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+
+	h := cw.Header()
+	if len(h.Get("Content-Type")) == 0 && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	compress := len(h.Get("Content-Encoding")) == 0 &&
+		!strings.Contains(h.Get("Cache-Control"), "no-transform") &&
+		cw.compression.isAllowed(h.Get("Content-Type"))
+	if compress {
+		addVary(h, "Accept-Encoding")
+	}
+	if compress && sizeOK {
+		h.Set("Content-Encoding", cw.compressor.Encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); len(etag) > 0 && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		if zw, err := cw.compressor.getWriter(cw.ResponseWriter); err == nil {
+			cw.zw = zw
+		} else {
+			h.Del("Content-Encoding")
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+	if len(cw.buf) > 0 {
+		if cw.zw != nil {
+			cw.zw.Write(cw.buf)
+		} else {
+			cw.ResponseWriter.Write(cw.buf)
+		}
+	}
+	cw.buf = nil
+}
+
+// Flush sends the buffered (and compressed) content to the client
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.decide(len(cw.buf) > 0)
+	if f, ok := cw.zw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection if nothing has been written yet
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok || cw.decided {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	cw.hijacked = true
+	return hj.Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter (used by http.ResponseController)
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) finish() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.decided {
+		if !cw.wroteHeader && len(cw.buf) == 0 {
+			// nothing was rendered, let the server write its default response
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.zw != nil {
+		cw.zw.Close()
+		cw.compressor.putWriter(cw.zw)
+		cw.zw = nil
+	}
+}
+
+func addVary(h http.Header, header string) {
+	for _, value := range h.Values("Vary") {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), header) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", header)
+}
@@ -0,0 +1,38 @@
+package beepboop
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestZstdCompression(t *testing.T) {
+	body := strings.Repeat("beepboop ", 1024)
+	c := NewCompression()
+	for i := 0; i < 2; i++ { // the second response reuses the pooled writer
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip;q=0.5, zstd")
+		rec := httptest.NewRecorder()
+		w, finish := c.wrap(rec, r)
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body)
+		finish()
+
+		if enc := rec.Header().Get("Content-Encoding"); enc != "zstd" {
+			t.Fatalf("Content-Encoding = %q", enc)
+		}
+		dec, err := zstd.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(dec)
+		dec.Close()
+		if err != nil || string(data) != body {
+			t.Fatalf("decoded %d bytes, error: %v", len(data), err)
+		}
+	}
+}
@@ -2,7 +2,13 @@ package beepboop
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/razzie/geoip-server/geoip"
@@ -13,24 +19,50 @@ import (
 type Context struct {
 	Context          context.Context
 	middlewares      []Middleware
+	middlewareNames  []string
 	DB               *DB
 	Logger           *log.Logger
 	GeoIPClient      geoip.Client
+	Tracer           *Tracer
+	IPInfoCache      *IPInfoCache
+	ClientIPResolver *ClientIPResolver
 	Limiters         map[string]*RateLimiter
+	logs             *LogPipeline
 	Layout           Layout
 	CookieExpiration time.Duration
+	ErrorRenderer    ErrorRenderer
+	Theme            *Theme
+	I18n             *I18n
+	Compression      *Compression
+	PageCache        *PageCache
+	pages            map[string]*Page
+	assets           *assetRegistry
+	DevMode          bool
 }
 
 func newContext(ctx context.Context, layout Layout, srv *Server) *Context {
 	return &Context{
 		Context:          ctx,
 		middlewares:      srv.Middlewares,
+		middlewareNames:  srv.middlewareNames,
 		DB:               srv.DB,
 		Logger:           srv.Logger,
 		GeoIPClient:      srv.GeoIPClient,
+		Tracer:           srv.Tracer,
+		IPInfoCache:      srv.IPInfoCache,
+		ClientIPResolver: srv.ClientIPResolver,
 		Limiters:         srv.Limiters,
+		logs:             srv.LogPipeline,
 		Layout:           layout,
 		CookieExpiration: srv.CookieExpiration,
+		ErrorRenderer:    srv.ErrorRenderer,
+		Theme:            srv.Theme,
+		I18n:             srv.I18n,
+		Compression:      srv.Compression,
+		PageCache:        srv.PageCache,
+		pages:            srv.pages,
+		assets:           srv.assets,
+		DevMode:          srv.DevMode,
 	}
 }
 
@@ -38,14 +70,29 @@ func (ctx *Context) runMiddlewares(pr *PageRequest) *View {
 	if ctx != pr.Context {
 		panic("different Context in PageRequest")
 	}
-	for _, middleware := range ctx.middlewares {
-		if view := middleware(pr); view != nil {
+	for i, middleware := range ctx.middlewares {
+		span := pr.startSpan(ctx.middlewareName(i))
+		view := middleware(pr)
+		span.End()
+		if view != nil {
 			return view
 		}
 	}
 	return nil
 }
 
+func (ctx *Context) startRequestSpan(r *http.Request, name string) (*http.Request, *Span) {
+	if ctx.Tracer == nil {
+		return r, nil
+	}
+	spanCtx, span := ctx.Tracer.StartRequestSpan(r, name)
+	ctx.Context = spanCtx
+	if ctx.DB != nil {
+		ctx.DB = ctx.DB.WithContext(spanCtx)
+	}
+	return r.WithContext(spanCtx), span
+}
+
 // GetServiceLimiter returns the rate limiter for the given service and IP
 func (ctx *Context) GetServiceLimiter(service, ip string) *rate.Limiter {
 	if limiter, ok := ctx.Limiters[service]; ok {
@@ -56,3 +103,54 @@ func (ctx *Context) GetServiceLimiter(service, ip string) *rate.Limiter {
 
 // ContextGetter ...
 type ContextGetter func(context.Context, Layout) *Context
+
+// PageURL returns the URL of a named page. The optional first arg is appended
+// to the page path and the rest are query parameters (key, value, key, value...).
+func (ctx *Context) PageURL(name string, args ...interface{}) (string, error) {
+	page, ok := ctx.pages[name]
+	if !ok {
+		return "", fmt.Errorf("unknown page: %s", name)
+	}
+	u := page.Path
+	if len(args)%2 == 1 {
+		var segments []string
+		for _, segment := range strings.Split(fmt.Sprint(args[0]), "/") {
+			segments = append(segments, url.PathEscape(segment))
+		}
+		u = strings.TrimSuffix(u, "/") + "/" + strings.TrimPrefix(strings.Join(segments, "/"), "/")
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		query := make(url.Values)
+		for i := 0; i < len(args); i += 2 {
+			query.Add(fmt.Sprint(args[i]), fmt.Sprint(args[i+1]))
+		}
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
+
+// AssetURL returns the fingerprinted URL of a static asset (served by a StaticAssetPage
+// or FSPage) that clients can cache forever, or the URL itself if it's not a known asset
+func (ctx *Context) AssetURL(path string) string {
+	return ctx.assets.fingerprint(path)
+}
+
+// middlewareName returns the span name of a middleware
+// (resolved when it was added unless Server.Middlewares was modified directly)
+func (ctx *Context) middlewareName(i int) string {
+	if ctx.Tracer == nil {
+		return ""
+	}
+	if len(ctx.middlewareNames) == len(ctx.middlewares) {
+		return ctx.middlewareNames[i]
+	}
+	return getFuncName(ctx.middlewares[i])
+}
+
+func getFuncName(f interface{}) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "middleware"
+}
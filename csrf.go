@@ -0,0 +1,84 @@
+package beepboop
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// CSRFFieldName is the name of the form field containing the CSRF token
+const CSRFFieldName = "csrf_token"
+
+// CSRFHeader is the header containing the CSRF token of requests made by scripts
+const CSRFHeader = "X-CSRF-Token"
+
+// CSRFToken returns the CSRF token of the client and creates one if it doesn't have any yet
+func (r *PageRequest) CSRFToken() string {
+	token, _ := r.csrfToken()
+	return token
+}
+
+// csrfToken returns the token and whether it's new (and its cookie needs to be set)
+func (r *PageRequest) csrfToken() (string, bool) {
+	r.noCache = true
+	if len(r.csrf) > 0 {
+		return r.csrf, false
+	}
+	if c, _ := r.Request.Cookie(CSRFFieldName); c != nil && len(c.Value) > 0 {
+		r.csrf = c.Value
+		return r.csrf, false
+	}
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	r.csrf = base64.RawURLEncoding.EncodeToString(buf)
+	r.csrfNew = true
+	return r.csrf, true
+}
+
+func (r *PageRequest) csrfCookie(token string) *http.Cookie {
+	r.csrfNew = false
+	return &http.Cookie{
+		Name:     CSRFFieldName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.Request.TLS != nil,
+	}
+}
+
+// CheckCSRFToken returns whether the request contains the CSRF token of the client
+// in its form or header
+func (r *PageRequest) CheckCSRFToken() bool {
+	c, _ := r.Request.Cookie(CSRFFieldName)
+	if c == nil || len(c.Value) == 0 {
+		return false
+	}
+	token := r.Request.Header.Get(CSRFHeader)
+	if len(token) == 0 {
+		token = r.Request.PostFormValue(CSRFFieldName)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.Value)) == 1
+}
+
+// CSRFMiddleware rejects POST, PUT, PATCH and DELETE requests without a valid CSRF token.
+// Forms should contain {{CSRFField}} and scripts should send the token in the X-CSRF-Token header.
+func CSRFMiddleware(pr *PageRequest) *View {
+	switch pr.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return nil
+	}
+	if !pr.CheckCSRFToken() {
+		pr.Log("invalid CSRF token")
+		return pr.ErrorView("Invalid CSRF token", http.StatusForbidden)
+	}
+	return nil
+}
+
+func csrfField(token string) template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s" />`,
+		CSRFFieldName, template.HTMLEscapeString(token)))
+}
@@ -1,6 +1,7 @@
 package beepboop
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -27,6 +28,7 @@ func NewDB(redisUrl string) (*DB, error) {
 	}
 
 	client := redis.NewClient(opt)
+	client.AddHook(redisTracingHook{})
 
 	if err := client.Ping().Err(); err != nil {
 		client.Close()
@@ -40,6 +42,19 @@ func NewDB(redisUrl string) (*DB, error) {
 	}, nil
 }
 
+// WithContext returns a shallow copy of the DB that uses the given context
+// for its commands (which makes them part of the trace in ctx)
+func (db *DB) WithContext(ctx context.Context) *DB {
+	clone := *db
+	clone.client = db.client.WithContext(ctx)
+	return &clone
+}
+
+// Close closes the connection to redis
+func (db *DB) Close() error {
+	return db.client.Close()
+}
+
 // CacheValue caches a value
 func (db *DB) CacheValue(key string, value interface{}, rewriteExisting bool) error {
 	data, err := json.Marshal(value)
@@ -58,9 +73,12 @@ func (db *DB) UncacheValue(key string) error {
 	return db.client.Del("beepboop-cache:" + key).Err()
 }
 
-// GetCachedValue tries to unmarshal a cached value
+// GetCachedValue tries to unmarshal a cached value and returns ErrCacheMiss if it isn't cached
 func (db *DB) GetCachedValue(key string, value interface{}) error {
 	data, err := db.client.Get("beepboop-cache:" + key).Result()
+	if err == redis.Nil {
+		return ErrCacheMiss
+	}
 	if err != nil {
 		return err
 	}
@@ -82,6 +100,44 @@ func (db *DB) IsWithinRateLimit(reqType, ip string, rate int) (bool, error) {
 	return int(incr.Val()) <= rate, nil
 }
 
+func (db *DB) cacheIPInfo(info *IPInfo, ttl time.Duration) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return db.client.Set("beepboop-ipinfo:"+info.IP, data, ttl).Err()
+}
+
+func (db *DB) getIPInfo(ip string) (*IPInfo, error) {
+	data, err := db.client.Get("beepboop-ipinfo:" + ip).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var info IPInfo
+	if err := json.Unmarshal([]byte(data), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (db *DB) getCert(name string) ([]byte, error) {
+	data, err := db.client.Get("beepboop-cert:" + name).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	return data, err
+}
+
+func (db *DB) putCert(name string, data []byte) error {
+	return db.client.Set("beepboop-cert:"+name, data, 0).Err()
+}
+
+func (db *DB) deleteCert(name string) error {
+	return db.client.Del("beepboop-cert:" + name).Err()
+}
+
 func (db *DB) addSessionAccess(sessionID, ip string, access AccessMap) error {
 	key := fmt.Sprintf("beepboop-session:%s:%s", sessionID, ip)
 	data, _ := db.client.Get(key).Result()
@@ -143,3 +199,150 @@ func (db *DB) getAccessMap(sessionID, ip string) (AccessMap, error) {
 
 	return access, nil
 }
+
+func (db *DB) getCachedPage(key string) (*cachedResponse, error) {
+	data, err := db.client.Get("beepboop-page:" + key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var resp cachedResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (db *DB) putCachedPage(key string, resp *cachedResponse, ttl time.Duration) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	return db.putTagged("beepboop-pagetag:", "beepboop-page:", key, data, ttl, resp.Tags...)
+}
+
+func (db *DB) invalidatePageTags(tags ...string) error {
+	return db.invalidateTags("beepboop-pagetag:", "beepboop-page:", tags...)
+}
+
+func (db *DB) getCacheEntry(key string) ([]byte, error) {
+	data, err := db.client.Get("beepboop-cache:" + key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	return data, err
+}
+
+func (db *DB) putCacheEntry(key string, data []byte, ttl time.Duration, tags ...string) error {
+	return db.putTagged("beepboop-cachetag:", "beepboop-cache:", key, data, ttl, tags...)
+}
+
+func (db *DB) deleteCacheKeys(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	delKeys := make([]string, len(keys))
+	for i, key := range keys {
+		delKeys[i] = "beepboop-cache:" + key
+	}
+	return db.client.Del(delKeys...).Err()
+}
+
+// extendTTL sets the TTL of a key unless it already expires later
+var extendTTL = redis.NewScript(`
+if redis.call("TTL", KEYS[1]) < tonumber(ARGV[1]) then
+	return redis.call("EXPIRE", KEYS[1], ARGV[1])
+end
+return 0`)
+
+func (db *DB) deleteCachePrefix(prefix string) error {
+	iter := db.client.Scan(0, "beepboop-cache:"+escapeGlob(prefix)+"*", 100).Iterator()
+	var keys []string
+	for iter.Next() {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return db.client.Del(keys...).Err()
+}
+
+// putTagged sets a key and adds it to the sets of its tags
+// (which expire when their last key does)
+func (db *DB) putTagged(tagPrefix, keyPrefix, key string, data []byte, ttl time.Duration, tags ...string) error {
+	pipe := db.client.TxPipeline()
+	pipe.Set(keyPrefix+key, data, ttl)
+	for _, tag := range tags {
+		tagKey := tagPrefix + tag
+		pipe.SAdd(tagKey, key)
+		extendTTL.Eval(pipe, []string{tagKey}, int64(ttl/time.Second)+1)
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+// invalidateTags deletes the keys in the sets of the given tags
+func (db *DB) invalidateTags(tagPrefix, keyPrefix string, tags ...string) error {
+	for _, tag := range tags {
+		tagKey := tagPrefix + tag
+		keys, err := db.client.SMembers(tagKey).Result()
+		if err != nil {
+			return err
+		}
+
+		delKeys := []string{tagKey}
+		for _, key := range keys {
+			delKeys = append(delKeys, keyPrefix+key)
+		}
+		if err := db.client.Del(delKeys...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type redisSpanKeyType struct{}
+
+var redisSpanKey = &redisSpanKeyType{}
+
+type redisTracingHook struct{}
+
+func (redisTracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, span := StartSpan(ctx, "redis "+cmd.Name(), SpanKindClient)
+	if span != nil {
+		span.SetAttribute("db.system", "redis")
+		ctx = context.WithValue(ctx, redisSpanKey, span)
+	}
+	return ctx, nil
+}
+
+func (redisTracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if span, ok := ctx.Value(redisSpanKey).(*Span); ok {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			span.SetError(err)
+		}
+		span.End()
+	}
+	return nil
+}
+
+func (redisTracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, span := StartSpan(ctx, "redis pipeline", SpanKindClient)
+	if span != nil {
+		span.SetAttribute("db.system", "redis")
+		span.SetAttribute("db.redis.commands", len(cmds))
+		ctx = context.WithValue(ctx, redisSpanKey, span)
+	}
+	return ctx, nil
+}
+
+func (redisTracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if span, ok := ctx.Value(redisSpanKey).(*Span); ok {
+		span.End()
+	}
+	return nil
+}
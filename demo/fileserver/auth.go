@@ -34,13 +34,10 @@ func AuthMiddleware(root string) beepboop.Middleware {
 
 // AuthPage returns a beepboop.Page that handles password authentication for protected directories
 func AuthPage(root string) *beepboop.Page {
-	contentTemplate, err := ioutil.ReadFile("demo/fileserver/template/auth.html")
-	if err != nil {
-		panic(err)
-	}
 	return &beepboop.Page{
-		Path:            "/.auth/",
-		ContentTemplate: string(contentTemplate),
+		Name:                "auth",
+		Path:                "/.auth/",
+		ContentTemplateFile: "auth.html",
 		Handler: func(r *beepboop.PageRequest) *beepboop.View {
 			return handleAuthPage(r, Directory(root))
 		},
@@ -2,12 +2,12 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/razzie/beepboop"
 )
@@ -17,8 +17,11 @@ var (
 	ErrOutsideRoot     = fmt.Errorf("File points outside of the root directory")
 	ErrSymlinkMaxDepth = fmt.Errorf("Symlink max depth exceeded")
 	ErrHiddenFile      = fmt.Errorf("Hidden files are forbidden")
+	errNotDir          = fmt.Errorf("Not a directory")
 )
 
+var dirCache = beepboop.NewCache[[]*Entry]("dir", 1024)
+
 // Directory ...
 type Directory string
 
@@ -84,13 +87,10 @@ func (root Directory) resolve(relPath string) (resolvedPath string, isDir bool,
 
 // DirectoryPage returns a beepboop.Page that handles the directory view
 func DirectoryPage(root string) *beepboop.Page {
-	contentTemplate, err := ioutil.ReadFile("demo/fileserver/template/directory.html")
-	if err != nil {
-		panic(err)
-	}
 	return &beepboop.Page{
-		Path:            "/",
-		ContentTemplate: string(contentTemplate),
+		Name:                "directory",
+		Path:                "/",
+		ContentTemplateFile: "directory.html",
 		Handler: func(r *beepboop.PageRequest) *beepboop.View {
 			return handleDirPage(r, Directory(root))
 		},
@@ -98,8 +98,9 @@ func DirectoryPage(root string) *beepboop.Page {
 }
 
 type dirView struct {
-	Dir     string
-	Entries []*Entry
+	Dir        string
+	Entries    []*Entry
+	Pagination *beepboop.Pagination
 }
 
 func handleDirPage(r *beepboop.PageRequest, root Directory) *beepboop.View {
@@ -109,28 +110,55 @@ func handleDirPage(r *beepboop.PageRequest, root Directory) *beepboop.View {
 		Dir: r.RelPath,
 	}
 
-	db := r.Context.DB
-	if db != nil && db.GetCachedValue("dir:"+uri, &v.Entries) == nil {
-		return r.Respond(v)
+	entries, err := dirCache.GetOrLoad(r.Context.DB, uri, time.Hour, func() ([]*Entry, error) {
+		return readDir(root, r.RelPath, uri)
+	})
+	if err == errNotDir {
+		file, err := root.Open(r.RelPath)
+		if err != nil {
+			return r.ErrorView(err.Error(), http.StatusInternalServerError)
+		}
+		return r.FileView(file, "", false)
 	}
-
-	file, err := root.Open(r.RelPath)
 	if err != nil {
 		return r.ErrorView(err.Error(), http.StatusInternalServerError)
 	}
+
+	// the parent directory stays on top of every page
+	var parent *Entry
+	if len(entries) > 0 && entries[0].Name == ".." {
+		parent, entries = entries[0], entries[1:]
+	}
+	list := beepboop.ApplyListQuery(r.ListQuery("filter:PrimaryType"), entries)
+	if r.IsAPI {
+		// streamed as a JSON array (or NDJSON), pagination is in the Link and X-Total-Count headers
+		return r.Respond(list.Items, beepboop.WithPagination(list.Pagination))
+	}
+	v.Entries = list.Items
+	if parent != nil {
+		v.Entries = append([]*Entry{parent}, list.Items...)
+	}
+	v.Pagination = list.Pagination
+	return r.Respond(v, beepboop.WithPagination(list.Pagination))
+}
+
+func readDir(root Directory, relPath, uri string) ([]*Entry, error) {
+	file, err := root.Open(relPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 	fi, err := file.Stat()
 	if err != nil {
-		file.Close()
-		return r.ErrorView(err.Error(), http.StatusInternalServerError)
+		return nil, err
 	}
 	if !fi.IsDir() {
-		return r.FileView(file, "", false)
+		return nil, errNotDir
 	}
-	defer file.Close()
 
 	files, err := file.Readdir(-1)
 	if err != nil {
-		return r.ErrorView(err.Error(), http.StatusInternalServerError)
+		return nil, err
 	}
 	entries := make([]*Entry, 0, len(files)+1)
 	if uri != "." {
@@ -143,13 +171,7 @@ func handleDirPage(r *beepboop.PageRequest, root Directory) *beepboop.View {
 		entries = append(entries, newEntry(fi, uri))
 	}
 	sortEntries(entries)
-
-	if db != nil {
-		db.CacheValue("dir:"+uri, entries, false)
-	}
-
-	v.Entries = entries
-	return r.Respond(v)
+	return entries, nil
 }
 
 func isHiddenFile(filename string) bool {
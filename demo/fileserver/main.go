@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"embed"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
-	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/razzie/beepboop"
 )
@@ -14,26 +18,77 @@ import (
 var (
 	RootDir   string
 	RedisAddr string
+	OTLPAddr  string
+	GeoIPDB   string
+	Proxies   string
 	Port      int
+	TLSCert   string
+	TLSKey    string
+	ACMEHosts string
+	DevMode   bool
 )
 
+//go:embed template
+var templateFS embed.FS
+
 func init() {
 	flag.StringVar(&RootDir, "root", "", "Root directory to serve")
 	flag.StringVar(&RedisAddr, "redis", "redis://localhost:6379", "Redis connection string")
+	flag.StringVar(&OTLPAddr, "otlp", "", "OpenTelemetry collector URL (tracing is disabled if empty)")
+	flag.StringVar(&GeoIPDB, "geoip-db", "", "Comma separated list of MaxMind DB files (the remote GeoIP service is used if empty)")
+	flag.StringVar(&Proxies, "trusted-proxies", "", "Comma separated list of trusted reverse proxy CIDRs")
 	flag.IntVar(&Port, "port", 8080, "HTTP port")
+	flag.StringVar(&TLSCert, "tls-cert", "", "TLS certificate file (TLS is disabled if empty)")
+	flag.StringVar(&TLSKey, "tls-key", "", "TLS key file")
+	flag.StringVar(&ACMEHosts, "acme", "", "Comma separated list of hostnames to obtain certificates for via ACME")
+	flag.BoolVar(&DevMode, "dev", false, "Load templates from demo/fileserver/template and reload them on change")
 	flag.Parse()
 
 	log.SetOutput(os.Stdout)
 }
 
 func main() {
-	srv := beepboop.NewServer()
-	srv.AddMiddlewares(AuthMiddleware(RootDir))
+	opts := []beepboop.ServerOption{
+		beepboop.WithAddr(fmt.Sprintf(":%d", Port)),
+		beepboop.WithTLS(TLSCert, TLSKey),
+	}
+	if len(GeoIPDB) > 0 {
+		geoipClient, err := beepboop.OpenMMDB(time.Minute, strings.Split(GeoIPDB, ",")...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, beepboop.WithGeoIPClient(geoipClient))
+	}
+
+	srv := beepboop.NewServer(opts...)
+	srv.EnableColorSchemeToggle()
+	if DevMode {
+		srv.TemplateFS = os.DirFS("demo/fileserver/template")
+		srv.DevMode = true
+	} else {
+		srv.TemplateFS, _ = fs.Sub(templateFS, "template")
+	}
+	if len(OTLPAddr) > 0 {
+		srv.Tracer = beepboop.NewTracer("fileserver", beepboop.NewOTLPExporter(OTLPAddr))
+		srv.Tracer.Logger = srv.Logger
+	}
+	if len(Proxies) > 0 {
+		if err := srv.SetTrustedProxies(strings.Split(Proxies, ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(ACMEHosts) > 0 {
+		srv.EnableACME(beepboop.ACMEConfig{Hosts: strings.Split(ACMEHosts, ",")})
+	}
+	srv.AddMiddlewares(beepboop.CSRFMiddleware, AuthMiddleware(RootDir))
 	srv.AddPages(DirectoryPage(RootDir), AuthPage(RootDir))
+	srv.AddCache(dirCache)
 
 	if err := srv.ConnectDB(RedisAddr); err != nil {
 		log.Print(err)
 	}
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", Port), srv))
+	if err := srv.Run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 }
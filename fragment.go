@@ -0,0 +1,95 @@
+package beepboop
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+type fragmentKey struct{}
+
+func withFragment(r *http.Request, name string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), fragmentKey{}, name))
+}
+
+func getFragment(r *http.Request) string {
+	name, _ := r.Context().Value(fragmentKey{}).(string)
+	return name
+}
+
+// IsFragment returns whether the request was made by htmx (or a similar library)
+// to update a part of the page (boosted requests still get the full page)
+func (r *PageRequest) IsFragment() bool {
+	h := r.Request.Header
+	return h.Get("HX-Request") == "true" && h.Get("HX-Boosted") != "true"
+}
+
+// FragmentTarget returns the id of the element targeted by the fragment request
+func (r *PageRequest) FragmentTarget() string {
+	return r.Request.Header.Get("HX-Target")
+}
+
+// FragmentView returns a View that only renders the named template of the page
+// (like one defined by {{block "name" .}}) if the request is a fragment request,
+// or the full page otherwise
+func (r *PageRequest) FragmentView(name string, data interface{}, opts ...ViewOption) *View {
+	v := &View{
+		StatusCode: http.StatusOK,
+		Data:       data,
+	}
+	WithHeader("Vary", "HX-Request")(v)
+	for _, opt := range opts {
+		opt(v)
+	}
+	req := r.Request
+	if r.IsFragment() {
+		req = withFragment(req, name)
+	}
+	v.renderer = func(w http.ResponseWriter) {
+		r.renderer(w, req, r.Title, data, v.StatusCode)
+	}
+	return v
+}
+
+// WithTrigger triggers a client side event (with optional detail) after the response is received
+func WithTrigger(event string, detail interface{}) ViewOption {
+	return func(view *View) {
+		if view.triggers == nil {
+			view.triggers = make(map[string]interface{})
+		}
+		view.triggers[event] = detail
+	}
+}
+
+// WithClientRedirect makes the client do a full page redirect to the given URL
+func WithClientRedirect(url string) ViewOption {
+	return WithHeader("HX-Redirect", url)
+}
+
+// WithClientRefresh makes the client do a full page refresh
+func WithClientRefresh() ViewOption {
+	return WithHeader("HX-Refresh", "true")
+}
+
+// WithPushURL pushes the given URL into the browser history
+func WithPushURL(url string) ViewOption {
+	return WithHeader("HX-Push-Url", url)
+}
+
+// WithRetarget overrides the element (CSS selector) the fragment is swapped into
+func WithRetarget(selector string) ViewOption {
+	return WithHeader("HX-Retarget", selector)
+}
+
+// WithReswap overrides how the fragment is swapped in (like "outerHTML")
+func WithReswap(swap string) ViewOption {
+	return WithHeader("HX-Reswap", swap)
+}
+
+func (view *View) triggerHeader() string {
+	if len(view.triggers) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(view.triggers)
+	return string(data)
+}
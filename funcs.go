@@ -1,58 +1,83 @@
 package beepboop
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
 	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
 )
 
-// TemplateFuncs is beepboop's built in template FuncMap
-var TemplateFuncs = template.FuncMap{
-	"TimeElapsed": func(then int64) string {
+// TemplateFuncs is beepboop's built in template FuncMap.
+// Request dependent funcs (like T, PageURL, CSRFField or SetQuery) are bound
+// to the request when a page is rendered.
+var TemplateFuncs = newTemplateFuncs()
+
+func newTemplateFuncs() template.FuncMap {
+	funcs := (*renderState)(nil).funcMap()
+	funcs["TimeElapsed"] = func(then int64) string {
 		return TimeElapsed(time.Now(), time.Unix(then, 0))
-	},
-	"ByteCountSI":  ByteCountSI,
-	"ByteCountIEC": ByteCountIEC,
+	}
+	funcs["ByteCountSI"] = ByteCountSI
+	funcs["ByteCountIEC"] = ByteCountIEC
+	funcs["Markdown"] = Markdown
+	return funcs
 }
 
-// TimeElapsed returns the elapsed time in human readable format (such as "5 days ago")
-func TimeElapsed(now time.Time, then time.Time) string {
-	text := func(unit string, amount int64) string {
-		if amount > 1 {
-			unit += "s"
-		}
-		if now.After(then) {
-			return fmt.Sprintf("%d %s ago", amount, unit)
-		}
-		return fmt.Sprintf("%d %s after", amount, unit)
-	}
+var errNoRequest = errors.New("not rendering a page request")
 
-	seconds := now.Unix() - then.Unix()
-	minutes := seconds / 60
-	hours := minutes / 60
-	days := hours / 24
-	weeks := days / 7
-	months := days / 30
-	years := days / 365
+// markdown renders GitHub flavored markdown without raw HTML and dangerous links
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// Markdown converts markdown to safe HTML
+func Markdown(source string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
 
-	switch {
-	case years > 0:
-		return text("year", years)
-	case months > 0:
-		return text("month", months)
-	case weeks > 0:
-		return text("week", weeks)
-	case days > 0:
-		return text("day", days)
-	case hours > 0:
-		return text("hour", hours)
-	case minutes > 0:
-		return text("minute", minutes)
-	case seconds > 10:
-		return text("second", seconds)
+func toTime(t interface{}) (time.Time, error) {
+	switch t := t.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, nil
+		}
+		return *t, nil
+	case int64:
+		return time.Unix(t, 0), nil
+	case int:
+		return time.Unix(int64(t), 0), nil
 	default:
-		return "just now"
+		return time.Time{}, fmt.Errorf("%T is not a time", t)
+	}
+}
+
+// timeTag returns a <time> element showing the relative or absolute time of t
+// (and the other one as title)
+func timeTag(l *Localizer, t interface{}, relative bool) (template.HTML, error) {
+	tt, err := toTime(t)
+	if err != nil {
+		return "", err
 	}
+	absolute := l.FormatDate(tt) + " " + tt.Format("15:04")
+	text, title := absolute, l.TimeElapsed(time.Now(), tt)
+	if relative {
+		text, title = title, text
+	}
+	return template.HTML(fmt.Sprintf(`<time datetime="%s" title="%s">%s</time>`,
+		tt.Format(time.RFC3339), template.HTMLEscapeString(title), template.HTMLEscapeString(text))), nil
+}
+
+// TimeElapsed returns the elapsed time in human readable format (such as "5 days ago")
+func TimeElapsed(now time.Time, then time.Time) string {
+	return (*Localizer)(nil).TimeElapsed(now, then)
 }
 
 // ByteCountSI ...
@@ -0,0 +1,169 @@
+package beepboop
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/biter777/countries"
+)
+
+// GeoFence describes which countries, continents and autonomous systems
+// are allowed to access the pages of a server
+type GeoFence struct {
+	Pages             []string // page paths the fence applies to (all pages if empty)
+	AllowCountries    []string // ISO 3166-1 alpha-2 country codes
+	DenyCountries     []string
+	AllowContinents   []string // continent codes: AF, AN, AS, EU, NA, OC, SA
+	DenyContinents    []string
+	AllowASNs         []uint
+	DenyASNs          []uint
+	AllowUnknown      bool     // allow requests if the location can't be determined
+	ExemptAccessTypes []string // sessions having access of these types are never blocked
+	Exempt            func(pr *PageRequest) bool
+	ErrorMessage      string
+	ErrorRenderer     ErrorRenderer
+}
+
+// GeoFenceMiddleware returns a middleware that blocks requests based on their location
+func GeoFenceMiddleware(fence GeoFence) Middleware {
+	errmsg := fence.ErrorMessage
+	if len(errmsg) == 0 {
+		errmsg = "Access from your location is not permitted"
+	}
+	renderer := fence.ErrorRenderer
+	if renderer == nil {
+		renderer = defaultErrorRenderer
+	}
+	return func(pr *PageRequest) *View {
+		if !fence.appliesTo(pr.PagePath) || fence.isExempt(pr) {
+			return nil
+		}
+		rec, err := pr.GeoIPRecord()
+		if err != nil {
+			pr.Log("geo-fence lookup failed: ", err)
+		}
+		if allowed, reason := fence.allows(rec); !allowed {
+			pr.Logf("geo-fence blocked request from %s: %s", pr.ClientIP, reason)
+			return pr.CustomErrorView(errmsg, http.StatusForbidden, renderer)
+		}
+		return nil
+	}
+}
+
+func (fence *GeoFence) appliesTo(pagePath string) bool {
+	if len(fence.Pages) == 0 {
+		return true
+	}
+	for _, p := range fence.Pages {
+		if p == pagePath {
+			return true
+		}
+	}
+	return false
+}
+
+func (fence *GeoFence) isExempt(pr *PageRequest) bool {
+	if fence.Exempt != nil && fence.Exempt(pr) {
+		return true
+	}
+	if len(fence.ExemptAccessTypes) > 0 {
+		sess := pr.Session()
+		for _, accessType := range fence.ExemptAccessTypes {
+			if sess.HasAccessType(accessType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (fence *GeoFence) allows(rec *GeoIPRecord) (bool, string) {
+	if rec == nil || len(rec.CountryCode) == 0 {
+		return fence.AllowUnknown, "unknown location"
+	}
+	country := strings.ToUpper(rec.CountryCode)
+	continent := strings.ToUpper(rec.ContinentCode)
+
+	if containsFold(fence.DenyCountries, country) {
+		return false, "denied country " + country
+	}
+	if len(continent) > 0 && containsFold(fence.DenyContinents, continent) {
+		return false, "denied continent " + continent
+	}
+	if rec.ASN != 0 && containsUint(fence.DenyASNs, rec.ASN) {
+		return false, "denied ASN " + rec.ASOrganization
+	}
+
+	hasAllowList := len(fence.AllowCountries)+len(fence.AllowContinents)+len(fence.AllowASNs) > 0
+	if !hasAllowList {
+		return true, ""
+	}
+	if containsFold(fence.AllowCountries, country) ||
+		(len(continent) > 0 && containsFold(fence.AllowContinents, continent)) ||
+		(rec.ASN != 0 && containsUint(fence.AllowASNs, rec.ASN)) {
+		return true, ""
+	}
+	return false, "country " + country + " is not allowed"
+}
+
+// geoIPRecordLookup is implemented by GeoIP clients that provide continent and ASN data
+type geoIPRecordLookup interface {
+	Lookup(ctx context.Context, hostname string) (*GeoIPRecord, error)
+}
+
+// GeoIPRecord returns the location, continent and ASN (if available) of the client IP
+func (r *PageRequest) GeoIPRecord() (*GeoIPRecord, error) {
+	if client, ok := r.Context.GeoIPClient.(geoIPRecordLookup); ok {
+		return client.Lookup(r.Request.Context(), r.ClientIP)
+	}
+
+	info, err := r.IPInfo()
+	if err != nil {
+		return nil, err
+	}
+	if info.Location == nil {
+		return nil, nil
+	}
+	rec := &GeoIPRecord{Location: *info.Location}
+	rec.ContinentCode = continentCode(countries.ByName(rec.CountryCode).Region())
+	return rec, nil
+}
+
+func continentCode(region countries.RegionCode) string {
+	switch region {
+	case countries.RegionAF:
+		return "AF"
+	case countries.RegionAN:
+		return "AN"
+	case countries.RegionAS:
+		return "AS"
+	case countries.RegionEU:
+		return "EU"
+	case countries.RegionNA:
+		return "NA"
+	case countries.RegionOC:
+		return "OC"
+	case countries.RegionSA:
+		return "SA"
+	}
+	return ""
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint(list []uint, value uint) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
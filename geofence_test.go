@@ -0,0 +1,99 @@
+package beepboop
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/razzie/geoip-server/geoip"
+)
+
+func TestGeoFenceAllows(t *testing.T) {
+	hu := &GeoIPRecord{
+		Location:       geoip.Location{CountryCode: "HU"},
+		ContinentCode:  "EU",
+		ASN:            64500,
+		ASOrganization: "Example Networks",
+	}
+	us := &GeoIPRecord{Location: geoip.Location{CountryCode: "us"}, ContinentCode: "NA"}
+	cases := []struct {
+		name    string
+		fence   GeoFence
+		rec     *GeoIPRecord
+		allowed bool
+	}{
+		{"no rules", GeoFence{}, hu, true},
+		{"unknown", GeoFence{}, nil, false},
+		{"unknown allowed", GeoFence{AllowUnknown: true}, &GeoIPRecord{}, true},
+		{"denied country", GeoFence{DenyCountries: []string{"hu"}}, hu, false},
+		{"other country denied", GeoFence{DenyCountries: []string{"HU"}}, us, true},
+		{"denied continent", GeoFence{DenyContinents: []string{"EU"}}, hu, false},
+		{"denied ASN", GeoFence{DenyASNs: []uint{64500}}, hu, false},
+		{"allowed country", GeoFence{AllowCountries: []string{"US"}}, us, true},
+		{"not allowed country", GeoFence{AllowCountries: []string{"US"}}, hu, false},
+		{"allowed continent", GeoFence{AllowContinents: []string{"EU"}}, hu, true},
+		{"allowed ASN", GeoFence{AllowCountries: []string{"US"}, AllowASNs: []uint{64500}}, hu, true},
+		{"deny wins over allow", GeoFence{AllowContinents: []string{"EU"}, DenyCountries: []string{"HU"}}, hu, false},
+	}
+	for _, tc := range cases {
+		if allowed, reason := tc.fence.allows(tc.rec); allowed != tc.allowed {
+			t.Errorf("%s: allowed = %v (%s), expected %v", tc.name, allowed, reason, tc.allowed)
+		}
+	}
+}
+
+func TestGeoFenceMiddleware(t *testing.T) {
+	city := newMMDBFixture(4, "Test-City")
+	city.insert("1.2.3.0/24", map[string]interface{}{
+		"continent": map[string]interface{}{"code": "EU"},
+		"country":   map[string]interface{}{"iso_code": "HU", "names": map[string]interface{}{"en": "Hungary"}},
+	})
+	city.insert("5.6.7.0/24", map[string]interface{}{
+		"continent": map[string]interface{}{"code": "NA"},
+		"country":   map[string]interface{}{"iso_code": "US", "names": map[string]interface{}{"en": "United States"}},
+	})
+	client, err := OpenMMDB(0, city.write(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(WithGeoIPClient(client))
+	srv.Logger = log.New(io.Discard, "", 0)
+	srv.AddMiddleware(GeoFenceMiddleware(GeoFence{
+		Pages:          []string{"/fenced"},
+		AllowCountries: []string{"HU"},
+		Exempt: func(pr *PageRequest) bool {
+			return pr.Request.Header.Get("X-Exempt") == "1"
+		},
+	}))
+	srv.AddPages(
+		&Page{Path: "/fenced", ContentTemplate: "fenced"},
+		&Page{Path: "/open", ContentTemplate: "open"},
+	)
+
+	cases := []struct {
+		path, remoteAddr string
+		exempt           bool
+		status           int
+	}{
+		{"/fenced", "1.2.3.4:1234", false, http.StatusOK},
+		{"/fenced", "5.6.7.8:1234", false, http.StatusForbidden},
+		{"/fenced", "9.9.9.9:1234", false, http.StatusForbidden},
+		{"/fenced", "5.6.7.8:1234", true, http.StatusOK},
+		{"/open", "5.6.7.8:1234", false, http.StatusOK},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.exempt {
+			r.Header.Set("X-Exempt", "1")
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s from %s (exempt: %v): status %d, expected %d", tc.path, tc.remoteAddr, tc.exempt, w.Code, tc.status)
+		}
+	}
+}
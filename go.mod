@@ -1,23 +1,34 @@
 module github.com/razzie/beepboop
 
-go 1.16
+go 1.18
 
 require (
 	github.com/Masterminds/sprig/v3 v3.2.3
-	github.com/asaskevich/govalidator v0.0.0-20230301143203-a9d515a09cc2 // indirect
-	github.com/biter777/countries v1.6.4 // indirect
+	github.com/andybalholm/brotli v1.0.5
+	github.com/biter777/countries v1.6.4
 	github.com/go-redis/redis/v7 v7.4.1
+	github.com/klauspost/compress v1.16.7
+	github.com/mssola/user_agent v0.6.0
+	github.com/razzie/babble v0.0.0-20201015224220-210f32b7a231
+	github.com/razzie/geoip-server v0.0.0-20220814153853-4fe0a07e7505
+	github.com/yuin/goldmark v1.4.13
+	golang.org/x/crypto v0.31.0
+	golang.org/x/time v0.3.0
+)
+
+require (
+	github.com/Masterminds/goutils v1.1.1 // indirect
+	github.com/Masterminds/semver/v3 v3.2.0 // indirect
+	github.com/asaskevich/govalidator v0.0.0-20230301143203-a9d515a09cc2 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/huandu/xstrings v1.4.0 // indirect
 	github.com/imdario/mergo v0.3.13 // indirect
 	github.com/mitchellh/copystructure v1.2.0 // indirect
-	github.com/mssola/user_agent v0.6.0
-	github.com/razzie/babble v0.0.0-20201015224220-210f32b7a231
-	github.com/razzie/geoip-server v0.0.0-20220814153853-4fe0a07e7505
-	github.com/razzie/reqip v0.0.0-20201102012254-b5eb0ae76a05
+	github.com/mitchellh/reflectwalk v1.0.2 // indirect
 	github.com/shopspring/decimal v1.3.1 // indirect
 	github.com/spf13/cast v1.5.0 // indirect
+	github.com/thedevsaddam/gojsonq v2.3.0+incompatible // indirect
 	github.com/tjarratt/babble v0.0.0-20191209142150-eecdf8c2339d // indirect
-	golang.org/x/crypto v0.31.0 // indirect
-	golang.org/x/time v0.3.0
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
@@ -0,0 +1,147 @@
+package beepboop
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// Hub broadcasts messages to the WebSockets that joined its topics. Hubs with the same name
+// in other replicas receive the broadcasts too if they Listen to the same MessageBus (like DB).
+type Hub struct {
+	Name   string
+	id     string
+	mtx    sync.Mutex
+	topics map[string]map[*WebSocket]bool
+	bus    MessageBus
+}
+
+type hubMessage struct {
+	Origin string      `json:"origin"`
+	Topic  string      `json:"topic"`
+	Type   MessageType `json:"type"`
+	Data   []byte      `json:"data"`
+}
+
+// NewHub returns a new Hub
+func NewHub(name string) *Hub {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return &Hub{
+		Name:   name,
+		id:     hex.EncodeToString(buf),
+		topics: make(map[string]map[*WebSocket]bool),
+	}
+}
+
+// Join subscribes a WebSocket to the broadcasts of a topic until it leaves or is closed
+func (hub *Hub) Join(topic string, ws *WebSocket) {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+	if hub.topics[topic] == nil {
+		hub.topics[topic] = make(map[*WebSocket]bool)
+	}
+	if hub.topics[topic][ws] {
+		return
+	}
+	hub.topics[topic][ws] = true
+	go func() {
+		<-ws.Context().Done()
+		hub.Leave(topic, ws)
+	}()
+}
+
+// Leave unsubscribes a WebSocket from a topic
+func (hub *Hub) Leave(topic string, ws *WebSocket) {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+	delete(hub.topics[topic], ws)
+	if len(hub.topics[topic]) == 0 {
+		delete(hub.topics, topic)
+	}
+}
+
+// Count returns the number of local WebSockets subscribed to a topic
+func (hub *Hub) Count(topic string) int {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+	return len(hub.topics[topic])
+}
+
+// Broadcast sends a message to the WebSockets subscribed to a topic in every replica
+func (hub *Hub) Broadcast(topic string, typ MessageType, data []byte) error {
+	hub.send(topic, typ, data)
+
+	hub.mtx.Lock()
+	bus := hub.bus
+	hub.mtx.Unlock()
+	if bus == nil {
+		return nil
+	}
+	msg, err := json.Marshal(&hubMessage{
+		Origin: hub.id,
+		Topic:  topic,
+		Type:   typ,
+		Data:   data,
+	})
+	if err != nil {
+		return err
+	}
+	return bus.PublishMessage("hub:"+hub.Name, msg)
+}
+
+// BroadcastJSON sends v as a JSON text message to the WebSockets subscribed to a topic in every replica
+func (hub *Hub) BroadcastJSON(topic string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return hub.Broadcast(topic, TextMessage, data)
+}
+
+// Listen receives the broadcasts of the hubs of other replicas and publishes the broadcasts of this hub to them
+func (hub *Hub) Listen(bus MessageBus) (func(), error) {
+	unsubscribe, err := bus.SubscribeMessages("hub:"+hub.Name, func(data []byte) {
+		var msg hubMessage
+		if err := json.Unmarshal(data, &msg); err == nil && msg.Origin != hub.id {
+			hub.send(msg.Topic, msg.Type, msg.Data)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	hub.mtx.Lock()
+	hub.bus = bus
+	hub.mtx.Unlock()
+	return func() {
+		unsubscribe()
+		hub.mtx.Lock()
+		if hub.bus == bus {
+			hub.bus = nil
+		}
+		hub.mtx.Unlock()
+	}, nil
+}
+
+// send writes a message to the local WebSockets of a topic concurrently
+func (hub *Hub) send(topic string, typ MessageType, data []byte) {
+	hub.mtx.Lock()
+	sockets := make([]*WebSocket, 0, len(hub.topics[topic]))
+	for ws := range hub.topics[topic] {
+		sockets = append(sockets, ws)
+	}
+	hub.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ws := range sockets {
+		wg.Add(1)
+		go func(ws *WebSocket) {
+			defer wg.Done()
+			if err := ws.WriteMessage(typ, data); err != nil {
+				hub.Leave(topic, ws)
+			}
+		}(ws)
+	}
+	wg.Wait()
+}
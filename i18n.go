@@ -0,0 +1,491 @@
+package beepboop
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCountryLocales maps GeoIP country codes to locales where the
+// lowercase country code isn't a language code
+var DefaultCountryLocales = map[string]string{
+	"US": "en", "GB": "en", "IE": "en", "AU": "en", "NZ": "en", "CA": "en",
+	"AT": "de", "CH": "de", "BE": "nl", "BR": "pt", "MX": "es", "AR": "es",
+	"CZ": "cs", "SE": "sv", "DK": "da", "JP": "ja", "CN": "zh", "TW": "zh",
+	"KR": "ko", "UA": "uk", "GR": "el", "EE": "et", "VN": "vi", "RS": "sr",
+}
+
+// I18n contains the message catalogs of an application and negotiates the locale of requests
+type I18n struct {
+	DefaultLocale  string
+	CountryLocales map[string]string // GeoIP country code -> locale (DefaultCountryLocales if nil)
+	GeoIPFallback  bool              // guess the locale from the client's country (local MMDB or cached IP info only)
+	catalogs       map[string]*Catalog
+}
+
+// WithI18n sets the message catalogs of the server and adds the "lang" page
+func WithI18n(i18n *I18n) ServerOption {
+	return func(srv *Server) {
+		srv.I18n = i18n
+	}
+}
+
+// SetI18n sets the message catalogs of the server
+// and adds the page that stores the locale chosen by the user in the session
+func (srv *Server) SetI18n(i18n *I18n) {
+	srv.I18n = i18n
+	if i18n != nil {
+		srv.addLocalePage()
+	}
+}
+
+func (srv *Server) addLocalePage() {
+	if srv.localePage {
+		return
+	}
+	srv.localePage = true
+	srv.AddPages(localePage())
+}
+
+// NewI18n returns a new I18n with an empty catalog for the default locale
+func NewI18n(defaultLocale string) *I18n {
+	i18n := &I18n{
+		DefaultLocale: defaultLocale,
+		catalogs:      make(map[string]*Catalog),
+	}
+	i18n.Catalog(defaultLocale)
+	return i18n
+}
+
+// Catalog returns the catalog of a locale and creates it if it doesn't exist
+func (i18n *I18n) Catalog(locale string) *Catalog {
+	key := normalizeLocale(locale)
+	c, ok := i18n.catalogs[key]
+	if !ok {
+		c = NewCatalog(locale)
+		i18n.catalogs[key] = c
+	}
+	return c
+}
+
+// LoadFS loads catalogs from the .json and .po files matching the given patterns,
+// using the file names as locales (like "hu.json" or "pt_BR.po")
+func (i18n *I18n) LoadFS(fsys fs.FS, patterns ...string) error {
+	files, err := globAll(fsys, patterns)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		ext := path.Ext(file)
+		c := i18n.Catalog(strings.TrimSuffix(path.Base(file), ext))
+		switch ext {
+		case ".json":
+			err = c.LoadJSON(data)
+		case ".po":
+			err = c.LoadPO(data)
+		default:
+			err = fmt.Errorf("unknown catalog format")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// Locales returns the available locales
+func (i18n *I18n) Locales() []string {
+	locales := make([]string, 0, len(i18n.catalogs))
+	for _, c := range i18n.catalogs {
+		locales = append(locales, c.Locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Negotiate returns the best available locale for an Accept-Language header
+// or an empty string if there is none
+func (i18n *I18n) Negotiate(acceptLanguage string) string {
+	type langQ struct {
+		lang string
+		q    float64
+	}
+	var langs []langQ
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		lang := normalizeLocale(fields[0])
+		if len(lang) == 0 || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		if q > 0 {
+			langs = append(langs, langQ{lang: lang, q: q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	for _, l := range langs {
+		if locale := i18n.match(l.lang); len(locale) > 0 {
+			return locale
+		}
+	}
+	return ""
+}
+
+// match returns the available locale matching the given one exactly or by its base language
+func (i18n *I18n) match(locale string) string {
+	if c, ok := i18n.catalogs[normalizeLocale(locale)]; ok {
+		return c.Locale
+	}
+	if c, ok := i18n.catalogs[baseLanguage(locale)]; ok {
+		return c.Locale
+	}
+	return ""
+}
+
+// CountryLocale returns the available locale of a GeoIP country code or an empty string
+func (i18n *I18n) CountryLocale(countryCode string) string {
+	countryLocales := i18n.CountryLocales
+	if countryLocales == nil {
+		countryLocales = DefaultCountryLocales
+	}
+	if locale, ok := countryLocales[strings.ToUpper(countryCode)]; ok {
+		return i18n.match(locale)
+	}
+	return i18n.match(countryCode)
+}
+
+// Localizer returns the localizer of a locale (falling back to the default locale)
+func (i18n *I18n) Localizer(locale string) *Localizer {
+	if i18n == nil {
+		return nil
+	}
+	l := &Localizer{
+		locale:   locale,
+		fallback: i18n.catalogs[normalizeLocale(i18n.DefaultLocale)],
+	}
+	if c, ok := i18n.catalogs[normalizeLocale(locale)]; ok {
+		l.catalog = c
+		if base, ok := i18n.catalogs[baseLanguage(locale)]; ok && base != c {
+			l.base = base
+		}
+	} else {
+		l.locale = i18n.DefaultLocale
+	}
+	return l
+}
+
+// Localizer translates messages and formats values for a locale.
+// A nil Localizer formats everything in English.
+type Localizer struct {
+	locale   string
+	catalog  *Catalog
+	base     *Catalog // catalog of the base language (like "pt" for "pt-BR")
+	fallback *Catalog // catalog of the default locale
+}
+
+// Locale returns the locale of the localizer
+func (l *Localizer) Locale() string {
+	if l == nil {
+		return ""
+	}
+	return l.locale
+}
+
+func (l *Localizer) lookup(context, msgid string, n int) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	key := messageKey(context, msgid)
+	for _, c := range []*Catalog{l.catalog, l.base, l.fallback} {
+		if msg, ok := c.lookup(key, n); ok {
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+// T translates a message and formats it with the given args (if any)
+func (l *Localizer) T(msgid string, args ...interface{}) string {
+	return l.TC("", msgid, args...)
+}
+
+// TC translates a message in a context (msgctxt in PO files) like T
+func (l *Localizer) TC(context, msgid string, args ...interface{}) string {
+	msg, ok := l.lookup(context, msgid, 1)
+	if !ok {
+		msg = msgid
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// N translates the plural form of a message for n and formats it
+// with the given args (or n if there are none)
+func (l *Localizer) N(msgid, msgidPlural string, n int, args ...interface{}) string {
+	return l.NC("", msgid, msgidPlural, n, args...)
+}
+
+// NC translates the plural form of a message in a context (msgctxt in PO files) like N
+func (l *Localizer) NC(context, msgid, msgidPlural string, n int, args ...interface{}) string {
+	msg, ok := l.lookup(context, msgid, n)
+	if !ok {
+		msg = msgid
+		if n != 1 {
+			msg = msgidPlural
+		}
+	}
+	if len(args) == 0 {
+		args = []interface{}{n}
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// TimeElapsed returns the elapsed time in human readable format (such as "5 days ago")
+func (l *Localizer) TimeElapsed(now time.Time, then time.Time) string {
+	text := func(unit string, amount int64) string {
+		if now.After(then) {
+			return l.N("%d "+unit+" ago", "%d "+unit+"s ago", int(amount))
+		}
+		return l.N("%d "+unit+" after", "%d "+unit+"s after", int(amount))
+	}
+
+	seconds := now.Unix() - then.Unix()
+	minutes := seconds / 60
+	hours := minutes / 60
+	days := hours / 24
+	weeks := days / 7
+	months := days / 30
+	years := days / 365
+
+	switch {
+	case years > 0:
+		return text("year", years)
+	case months > 0:
+		return text("month", months)
+	case weeks > 0:
+		return text("week", weeks)
+	case days > 0:
+		return text("day", days)
+	case hours > 0:
+		return text("hour", hours)
+	case minutes > 0:
+		return text("minute", minutes)
+	case seconds > 10:
+		return text("second", seconds)
+	default:
+		return l.T("just now")
+	}
+}
+
+// numberFormats contains the decimal and grouping separators of languages that don't use "." and ","
+var numberFormats = map[string][2]string{
+	"de": {",", "."}, "nl": {",", "."}, "it": {",", "."}, "es": {",", "."}, "pt": {",", "."},
+	"da": {",", "."}, "el": {",", "."}, "id": {",", "."}, "tr": {",", "."}, "sr": {",", "."},
+	"hr": {",", "."}, "ro": {",", "."}, "fr": {",", " "}, "hu": {",", " "},
+	"cs": {",", " "}, "sk": {",", " "}, "pl": {",", " "}, "ru": {",", " "},
+	"uk": {",", " "}, "sv": {",", " "}, "fi": {",", " "}, "nb": {",", " "},
+}
+
+func (l *Localizer) separators() (decimal, group string) {
+	if f, ok := numberFormats[baseLanguage(l.Locale())]; ok {
+		return f[0], f[1]
+	}
+	return ".", ","
+}
+
+// FormatNumber formats an integer or float with the decimal and grouping separators of the locale
+func (l *Localizer) FormatNumber(n interface{}) string {
+	var s string
+	switch n := n.(type) {
+	case float32:
+		s = strconv.FormatFloat(float64(n), 'f', -1, 32)
+	case float64:
+		s = strconv.FormatFloat(n, 'f', -1, 64)
+	default:
+		s = fmt.Sprint(n)
+	}
+	decimal, group := l.separators()
+
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	sign := ""
+	if strings.HasPrefix(intPart, "-") {
+		sign, intPart = "-", intPart[1:]
+	}
+	var grouped strings.Builder
+	for i, digit := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			grouped.WriteString(group)
+		}
+		grouped.WriteRune(digit)
+	}
+	if len(fracPart) > 0 {
+		return sign + grouped.String() + decimal + fracPart
+	}
+	return sign + grouped.String()
+}
+
+// dateFormats contains the numeric date layouts of locales that don't use ISO 8601
+var dateFormats = map[string]string{
+	"en":    "01/02/2006",
+	"en-gb": "02/01/2006",
+	"de":    "02.01.2006",
+	"ru":    "02.01.2006",
+	"pl":    "02.01.2006",
+	"cs":    "02.01.2006",
+	"fr":    "02/01/2006",
+	"es":    "02/01/2006",
+	"it":    "02/01/2006",
+	"pt":    "02/01/2006",
+	"nl":    "02-01-2006",
+	"hu":    "2006. 01. 02.",
+	"ja":    "2006/01/02",
+	"zh":    "2006/01/02",
+}
+
+// FormatDate formats the date of t in the numeric format of the locale
+func (l *Localizer) FormatDate(t time.Time) string {
+	locale := normalizeLocale(l.Locale())
+	if layout, ok := dateFormats[locale]; ok {
+		return t.Format(layout)
+	}
+	if layout, ok := dateFormats[baseLanguage(locale)]; ok && len(locale) > 0 {
+		return t.Format(layout)
+	}
+	return t.Format("2006-01-02")
+}
+
+// ByteCountSI formats a size in SI units (like "1,2 MB")
+func (l *Localizer) ByteCountSI(b int64) string {
+	return l.localizeDecimal(ByteCountSI(b))
+}
+
+// ByteCountIEC formats a size in IEC units (like "1,2 MiB")
+func (l *Localizer) ByteCountIEC(b int64) string {
+	return l.localizeDecimal(ByteCountIEC(b))
+}
+
+func (l *Localizer) localizeDecimal(s string) string {
+	if decimal, _ := l.separators(); decimal != "." {
+		return strings.Replace(s, ".", decimal, 1)
+	}
+	return s
+}
+
+type localizerKey struct{}
+
+func withLocalizer(r *http.Request, l *Localizer) *http.Request {
+	if l == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), localizerKey{}, l))
+}
+
+func getLocalizer(r *http.Request) *Localizer {
+	l, _ := r.Context().Value(localizerKey{}).(*Localizer)
+	return l
+}
+
+// Locale returns the locale of the request (or an empty string if i18n is disabled).
+// The locale is chosen by the user (see SetLocale), negotiated from the Accept-Language
+// header or guessed from the client's country (if GeoIPFallback is enabled), falling back
+// to the default locale.
+func (r *PageRequest) Locale() string {
+	i18n := r.Context.I18n
+	if i18n == nil {
+		return ""
+	}
+	if locale := i18n.match(r.Session().Preference("lang")); len(locale) > 0 {
+		return locale
+	}
+	if locale := i18n.Negotiate(r.Request.Header.Get("Accept-Language")); len(locale) > 0 {
+		return locale
+	}
+	if i18n.GeoIPFallback {
+		if locale := i18n.CountryLocale(r.localCountryCode()); len(locale) > 0 {
+			return locale
+		}
+	}
+	return i18n.DefaultLocale
+}
+
+// Localizer returns the localizer of the request (nil but safe to use if i18n is disabled)
+func (r *PageRequest) Localizer() *Localizer {
+	if l := getLocalizer(r.Request); l != nil {
+		return l
+	}
+	return r.Context.I18n.Localizer(r.Locale())
+}
+
+// T translates a message to the locale of the request
+func (r *PageRequest) T(msgid string, args ...interface{}) string {
+	return r.Localizer().T(msgid, args...)
+}
+
+// TC translates a message in a context (msgctxt in PO files) to the locale of the request
+func (r *PageRequest) TC(context, msgid string, args ...interface{}) string {
+	return r.Localizer().TC(context, msgid, args...)
+}
+
+// localCountryCode returns the country of the client if it's known without remote lookups
+// (from a local MMDB or the IP info cache), so the locale never waits for a GeoIP service
+func (r *PageRequest) localCountryCode() string {
+	if client, ok := r.Context.GeoIPClient.(*MMDBClient); ok {
+		if rec, err := client.Lookup(r.Request.Context(), r.ClientIP); err == nil {
+			return rec.CountryCode
+		}
+		return ""
+	}
+	if info := r.Context.IPInfoCache.get(r.ClientIP); info != nil && info.Location != nil {
+		return info.Location.CountryCode
+	}
+	return ""
+}
+
+// SetLocale returns a ViewOption that stores the locale chosen by the user in the session
+// (an empty locale resets it to the negotiated one).
+// Links to "lang?set=<locale>" do the same without a page handler.
+func (r *PageRequest) SetLocale(locale string) ViewOption {
+	return func(*View) {
+		if err := r.Session().SetPreference("lang", locale); err != nil {
+			r.Log(err)
+		}
+	}
+}
+
+// localePage stores the locale chosen by the user (like "lang?set=hu")
+// in the session and redirects back to the referring page
+func localePage() *Page {
+	handler := func(pr *PageRequest) *View {
+		locale := pr.Context.I18n.match(pr.Request.FormValue("set"))
+		if err := pr.Session().SetPreference("lang", locale); err != nil {
+			pr.Log(err)
+		}
+		return pr.RedirectView(referrerPath(pr.Request))
+	}
+	return &Page{
+		Path:           "/lang",
+		Handler:        handler,
+		OnlyLogOnError: true,
+	}
+}
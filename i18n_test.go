@@ -0,0 +1,82 @@
+package beepboop
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/razzie/geoip-server/geoip"
+)
+
+func TestLocalePage(t *testing.T) {
+	// user pages can use /lang without i18n
+	srv := NewServer()
+	srv.Logger = log.New(io.Discard, "", 0)
+	srv.AddPages(&Page{Path: "/lang", ContentTemplate: "custom"})
+
+	srv = NewServer()
+	srv.Logger = log.New(io.Discard, "", 0)
+	i18n := NewI18n("en")
+	i18n.Catalog("hu").Set("Hello", "Szia")
+	srv.SetI18n(i18n)
+	srv.SetI18n(i18n)
+	srv.AddPages(&Page{Path: "/page", ContentTemplate: `{{T "Hello"}}`})
+
+	req := httptest.NewRequest(http.MethodGet, "/lang?set=hu", nil)
+	req.Header.Set("Referer", "http://example.com/page")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/page" {
+		t.Fatalf("status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "preference-lang" || cookies[0].Value != "hu" {
+		t.Fatalf("unexpected session cookies: %v", cookies)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.Header.Set("Accept-Language", "en")
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, "Szia") || !strings.Contains(body, `lang="hu"`) {
+		t.Errorf("the page doesn't use the locale of the session: %s", body)
+	}
+}
+
+func TestLocaleGeoIPFallback(t *testing.T) {
+	client := &testGeoIPClient{loc: &geoip.Location{CountryCode: "HU"}}
+	srv := NewServer(WithGeoIPClient(client), WithI18n(NewI18n("en")))
+	srv.Logger = log.New(io.Discard, "", 0)
+	srv.LogPipeline = nil
+	srv.I18n.Catalog("hu")
+	srv.AddPages(&Page{Path: "/page", OnlyLogOnError: true})
+	render := func() string {
+		r := httptest.NewRequest(http.MethodGet, "/page", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	if body := render(); !strings.Contains(body, `lang="en"`) {
+		t.Errorf("locale without GeoIP fallback: %s", body)
+	}
+	srv.I18n.GeoIPFallback = true
+	if body := render(); !strings.Contains(body, `lang="en"`) {
+		t.Errorf("locale guessed before the IP was looked up: %s", body)
+	}
+	if client.calls != 0 {
+		t.Errorf("rendering made %d remote GeoIP lookups", client.calls)
+	}
+
+	// IP info that has already been looked up (like by the request logger) is used
+	srv.IPInfoCache.Lookup(context.Background(), "192.0.2.1", client, nil)
+	if body := render(); !strings.Contains(body, `lang="hu"`) {
+		t.Errorf("locale with cached IP info: %s", body)
+	}
+}
@@ -0,0 +1,199 @@
+package beepboop
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// Invalidation is an event that makes the replicas of a server evict entries of their local caches
+type Invalidation struct {
+	Cache  string   `json:"cache"`            // name of the cache
+	Origin string   `json:"origin"`           // ID of the publishing cache (which ignores its own events)
+	Keys   []string `json:"keys,omitempty"`   // keys to evict
+	Tags   []string `json:"tags,omitempty"`   // tags of the entries to evict
+	Prefix string   `json:"prefix,omitempty"` // evict the keys with this prefix (if not empty)
+}
+
+// InvalidationBus delivers invalidation events to every subscriber (DB uses redis pub/sub)
+type InvalidationBus interface {
+	Publish(inv Invalidation) error
+	Subscribe(handler func(Invalidation)) (unsubscribe func(), err error)
+}
+
+// MessageBus publishes messages to the subscribers of a channel in every replica (DB uses redis pub/sub)
+type MessageBus interface {
+	PublishMessage(channel string, data []byte) error
+	SubscribeMessages(channel string, handler func(data []byte)) (unsubscribe func(), err error)
+}
+
+// LocalCache is an in-memory cache that can be kept in sync with the caches of other replicas
+type LocalCache interface {
+	Listen(bus InvalidationBus) (stop func(), err error)
+}
+
+// LoopbackBus is an in-process InvalidationBus and MessageBus that delivers messages
+// synchronously (useful for tests and for servers that don't share a DB)
+type LoopbackBus struct {
+	mtx      sync.Mutex
+	nextID   int
+	handlers map[string]map[int]func([]byte)
+}
+
+// NewLoopbackBus returns a new LoopbackBus
+func NewLoopbackBus() *LoopbackBus {
+	return &LoopbackBus{
+		handlers: make(map[string]map[int]func([]byte)),
+	}
+}
+
+// PublishMessage delivers a message to every subscriber of the channel
+func (bus *LoopbackBus) PublishMessage(channel string, data []byte) error {
+	bus.mtx.Lock()
+	handlers := make([]func([]byte), 0, len(bus.handlers[channel]))
+	for _, handler := range bus.handlers[channel] {
+		handlers = append(handlers, handler)
+	}
+	bus.mtx.Unlock()
+	for _, handler := range handlers {
+		handler(data)
+	}
+	return nil
+}
+
+// SubscribeMessages registers a handler of the messages of a channel
+func (bus *LoopbackBus) SubscribeMessages(channel string, handler func([]byte)) (func(), error) {
+	bus.mtx.Lock()
+	defer bus.mtx.Unlock()
+	id := bus.nextID
+	bus.nextID++
+	if bus.handlers[channel] == nil {
+		bus.handlers[channel] = make(map[int]func([]byte))
+	}
+	bus.handlers[channel][id] = handler
+	return func() {
+		bus.mtx.Lock()
+		defer bus.mtx.Unlock()
+		delete(bus.handlers[channel], id)
+	}, nil
+}
+
+// Publish delivers an invalidation event to every subscriber
+func (bus *LoopbackBus) Publish(inv Invalidation) error {
+	return publishInvalidation(bus, inv)
+}
+
+// Subscribe registers a handler of invalidation events
+func (bus *LoopbackBus) Subscribe(handler func(Invalidation)) (func(), error) {
+	return subscribeInvalidations(bus, handler)
+}
+
+// PublishMessage publishes a message to the subscribers of a channel in every replica
+func (db *DB) PublishMessage(channel string, data []byte) error {
+	return db.client.Publish("beepboop-channel:"+channel, data).Err()
+}
+
+// SubscribeMessages calls handler with the messages of a channel published by any replica.
+// The subscription survives reconnects, but messages published while the connection
+// to redis is down are lost.
+func (db *DB) SubscribeMessages(channel string, handler func([]byte)) (func(), error) {
+	pubsub := db.client.Subscribe("beepboop-channel:" + channel)
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	go func() {
+		for msg := range pubsub.Channel() {
+			handler([]byte(msg.Payload))
+		}
+	}()
+	return func() { pubsub.Close() }, nil
+}
+
+// Publish publishes an invalidation event to every replica
+func (db *DB) Publish(inv Invalidation) error {
+	return publishInvalidation(db, inv)
+}
+
+// Subscribe calls handler with the invalidation events published by any replica.
+// Events published while the connection to redis is down are lost and the local caches
+// are not resynced after reconnecting, so they may serve stale entries until their TTL expires.
+func (db *DB) Subscribe(handler func(Invalidation)) (func(), error) {
+	return subscribeInvalidations(db, handler)
+}
+
+const invalidationChannel = "invalidation"
+
+func publishInvalidation(bus MessageBus, inv Invalidation) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return bus.PublishMessage(invalidationChannel, data)
+}
+
+func subscribeInvalidations(bus MessageBus, handler func(Invalidation)) (func(), error) {
+	return bus.SubscribeMessages(invalidationChannel, func(data []byte) {
+		var inv Invalidation
+		if err := json.Unmarshal(data, &inv); err == nil {
+			handler(inv)
+		}
+	})
+}
+
+// invalidationListener publishes the invalidations of a local cache and
+// receives the ones of other replicas
+type invalidationListener struct {
+	id  string
+	mtx sync.Mutex
+	bus InvalidationBus
+}
+
+func (l *invalidationListener) listen(bus InvalidationBus, cache string, evict func(Invalidation)) (func(), error) {
+	l.mtx.Lock()
+	if len(l.id) == 0 {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		l.id = hex.EncodeToString(buf)
+	}
+	id := l.id
+	l.mtx.Unlock()
+
+	unsubscribe, err := bus.Subscribe(func(inv Invalidation) {
+		if inv.Cache == cache && inv.Origin != id {
+			evict(inv)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.mtx.Lock()
+	l.bus = bus
+	l.mtx.Unlock()
+	return func() {
+		unsubscribe()
+		l.mtx.Lock()
+		if l.bus == bus {
+			l.bus = nil
+		}
+		l.mtx.Unlock()
+	}, nil
+}
+
+func (l *invalidationListener) publish(inv Invalidation) error {
+	l.mtx.Lock()
+	bus := l.bus
+	inv.Origin = l.id
+	l.mtx.Unlock()
+	if bus == nil {
+		return nil
+	}
+	return bus.Publish(inv)
+}
+
+// escapeGlob escapes the special characters of redis key patterns
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
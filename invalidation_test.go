@@ -0,0 +1,57 @@
+package beepboop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoopbackBusInvalidation(t *testing.T) {
+	bus := NewLoopbackBus()
+	a := NewCache[string]("test", 16)
+	b := NewCache[string]("test", 16)
+	other := NewCache[string]("other", 16)
+	// populated before listening, so that the caches don't evict each other's values yet
+	for _, c := range []*Cache[string]{a, b, other} {
+		c.Set(nil, "key", "value", time.Minute)
+		c.Set(nil, "tagged", "value", time.Minute, "tag")
+		c.Set(nil, "user:1", "value", time.Minute)
+		c.Set(nil, "user:2", "value", time.Minute)
+		c.Set(nil, "post:1", "value", time.Minute)
+		stop, err := c.Listen(bus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+	}
+
+	cached := func(c *Cache[string], key string) bool {
+		_, err := c.Get(nil, key)
+		return err == nil
+	}
+	expect := func(step string, c *Cache[string], key string, expected bool) {
+		if cached(c, key) != expected {
+			t.Errorf("%s: cached(%q) = %v, expected %v", step, key, !expected, expected)
+		}
+	}
+
+	a.Delete(nil, "key")
+	expect("key", a, "key", false)
+	expect("key", b, "key", false)
+	expect("key", other, "key", true)
+
+	a.InvalidateTags(nil, "tag")
+	expect("tag", b, "tagged", false)
+	expect("tag", b, "user:1", true)
+	expect("tag", other, "tagged", true)
+
+	b.InvalidatePrefix(nil, "user:")
+	expect("prefix", a, "user:1", false)
+	expect("prefix", a, "user:2", false)
+	expect("prefix", a, "post:1", true)
+	expect("prefix", other, "user:1", true)
+
+	// setting a value evicts the stale copies of the other caches, but not its own
+	a.Set(nil, "post:1", "new value", time.Minute)
+	expect("set", a, "post:1", true)
+	expect("set", b, "post:1", false)
+}
@@ -0,0 +1,195 @@
+package beepboop
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/razzie/geoip-server/geoip"
+)
+
+// IPInfo contains the location and hostnames of an IP address
+type IPInfo struct {
+	IP        string          `json:"ip"`
+	Location  *geoip.Location `json:"location,omitempty"`
+	Hostnames []string        `json:"hostnames,omitempty"`
+}
+
+// String returns the location of the IP or its hostnames if the location is unknown
+func (info *IPInfo) String() string {
+	if info.Location != nil {
+		return info.Location.String()
+	}
+	return strings.Join(info.Hostnames, ", ")
+}
+
+// IPInfoCache is an in-memory LRU cache of IP address information
+// that can optionally persist entries in DB
+type IPInfoCache struct {
+	TTL      time.Duration
+	mtx      sync.Mutex
+	size     int
+	lru      *list.List
+	entries  map[string]*list.Element
+	listener invalidationListener
+}
+
+type ipInfoCacheEntry struct {
+	info    *IPInfo
+	expires time.Time
+}
+
+// NewIPInfoCache returns a new IPInfoCache
+func NewIPInfoCache(size int, ttl time.Duration) *IPInfoCache {
+	return &IPInfoCache{
+		TTL:     ttl,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Lookup returns the cached information of an IP address or
+// looks it up using the GeoIP client and reverse DNS if it isn't cached yet
+func (c *IPInfoCache) Lookup(ctx context.Context, ip string, geoipClient geoip.Client, db *DB) (*IPInfo, error) {
+	if info := c.get(ip); info != nil {
+		return info, nil
+	}
+	if db != nil {
+		db = db.WithContext(ctx)
+		if info, err := db.getIPInfo(ip); err == nil {
+			c.put(info)
+			return info, nil
+		}
+	}
+
+	info, err := lookupIPInfo(ctx, ip, geoipClient)
+	if err != nil {
+		return info, err
+	}
+	c.put(info)
+	if db != nil {
+		db.cacheIPInfo(info, c.ttl())
+	}
+	return info, nil
+}
+
+// Remove removes an IP address from the cache (and the caches of other replicas if it listens to them)
+func (c *IPInfoCache) Remove(ip string) {
+	if c == nil {
+		return
+	}
+	inv := Invalidation{Cache: "ipinfo", Keys: []string{ip}}
+	c.evict(inv)
+	c.listener.publish(inv)
+}
+
+// Listen evicts the IP addresses removed by the caches of other replicas
+// and publishes the removals of this cache to them
+func (c *IPInfoCache) Listen(bus InvalidationBus) (func(), error) {
+	if c == nil {
+		return func() {}, nil
+	}
+	return c.listener.listen(bus, "ipinfo", c.evict)
+}
+
+func (c *IPInfoCache) evict(inv Invalidation) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, ip := range inv.Keys {
+		if elem, ok := c.entries[ip]; ok {
+			c.lru.Remove(elem)
+			delete(c.entries, ip)
+		}
+	}
+	if len(inv.Prefix) > 0 {
+		for ip, elem := range c.entries {
+			if strings.HasPrefix(ip, inv.Prefix) {
+				c.lru.Remove(elem)
+				delete(c.entries, ip)
+			}
+		}
+	}
+}
+
+func (c *IPInfoCache) ttl() time.Duration {
+	if c == nil {
+		return time.Hour
+	}
+	return c.TTL
+}
+
+func (c *IPInfoCache) get(ip string) *IPInfo {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	elem, ok := c.entries[ip]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*ipInfoCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, ip)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry.info
+}
+
+func (c *IPInfoCache) put(info *IPInfo) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry := &ipInfoCacheEntry{
+		info:    info,
+		expires: time.Now().Add(c.TTL),
+	}
+	if elem, ok := c.entries[info.IP]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[info.IP] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*ipInfoCacheEntry).info.IP)
+	}
+}
+
+// lookupAddr is the reverse DNS resolver of IP info lookups
+var lookupAddr = net.DefaultResolver.LookupAddr
+
+// isLocated reports whether a GeoIP client actually found an IP (an MMDB miss returns an empty location)
+func isLocated(loc *geoip.Location) bool {
+	return loc != nil && (len(loc.CountryCode) > 0 || len(loc.Country) > 0 || len(loc.City) > 0 || len(loc.ISP) > 0)
+}
+
+func lookupIPInfo(ctx context.Context, ip string, geoipClient geoip.Client) (*IPInfo, error) {
+	info := &IPInfo{IP: ip}
+	if geoipClient != nil {
+		ctx, span := StartSpan(ctx, "geoip GetLocation", SpanKindClient)
+		span.SetAttribute("net.peer.ip", ip)
+		loc, err := geoipClient.GetLocation(ctx, ip)
+		span.SetError(err)
+		span.End()
+		if isLocated(loc) {
+			info.Location = loc
+			return info, nil
+		}
+	}
+	hostnames, err := lookupAddr(ctx, ip)
+	if err != nil && ctx.Err() != nil {
+		return info, err
+	}
+	info.Hostnames = hostnames
+	return info, nil
+}
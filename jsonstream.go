@@ -0,0 +1,177 @@
+package beepboop
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// jsonStreamFlushInterval is the number of slice elements written between flushes
+// (channel elements are flushed right away)
+const jsonStreamFlushInterval = 64
+
+// jsonStream encodes the elements of a slice or channel one by one
+type jsonStream struct {
+	value  reflect.Value
+	r      *http.Request
+	ndjson bool
+}
+
+// newJSONStream returns a jsonStream if data is a slice, array or receivable channel
+func newJSONStream(data interface{}, r *http.Request) *jsonStream {
+	if _, ok := data.(json.Marshaler); ok {
+		return nil
+	}
+	value := reflect.ValueOf(data)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		// []byte is encoded as a base64 string
+		if value.Type().Elem().Kind() == reflect.Uint8 || (value.Kind() == reflect.Slice && value.IsNil()) {
+			return nil
+		}
+	case reflect.Chan:
+		if value.Type().ChanDir()&reflect.RecvDir == 0 {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return &jsonStream{
+		value:  value,
+		r:      r,
+		ndjson: acceptsNDJSON(r),
+	}
+}
+
+// acceptsNDJSON returns whether the request prefers newline delimited JSON
+func acceptsNDJSON(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	for _, value := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(part))
+			switch mediaType {
+			case "application/x-ndjson", "application/jsonl", "application/jsonlines":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (stream *jsonStream) render(w http.ResponseWriter, statusCode int) {
+	addVary(w.Header(), "Accept")
+	if stream.ndjson {
+		setDefaultContentType(w, "application/x-ndjson")
+	} else {
+		setDefaultContentType(w, "application/json; charset=utf-8")
+	}
+	w.WriteHeader(statusCode)
+	flusher, _ := w.(http.Flusher)
+
+	var buf bytes.Buffer
+	var failed bool
+	count := 0
+	write := func(elem reflect.Value, flush bool) bool {
+		buf.Reset()
+		if err := stream.encode(&buf, elem.Interface(), count == 0); err != nil {
+			// the status is already sent, so leave the output unterminated for the client to notice
+			stream.log("JSON stream stopped: ", err)
+			failed = true
+			return false
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return false
+		}
+		count++
+		if flusher != nil && (flush || count%jsonStreamFlushInterval == 0) {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	if !stream.ndjson {
+		w.Write([]byte("["))
+	}
+	if stream.value.Kind() == reflect.Chan {
+		stream.receive(write)
+	} else {
+		for i := 0; i < stream.value.Len(); i++ {
+			if !write(stream.value.Index(i), false) {
+				return
+			}
+		}
+	}
+	if !stream.ndjson && !failed {
+		if count > 0 {
+			w.Write([]byte("\n"))
+		}
+		w.Write([]byte("]"))
+	}
+}
+
+// log logs to the logger of the page request (if any)
+func (stream *jsonStream) log(a ...interface{}) {
+	if stream.r != nil {
+		if pr := getPageRequest(stream.r); pr != nil {
+			pr.Log(a...)
+			return
+		}
+	}
+	log.Print(a...)
+}
+
+// receive writes the elements of the channel until it's closed or the request is done
+func (stream *jsonStream) receive(write func(elem reflect.Value, flush bool) bool) {
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: stream.value}}
+	if stream.r != nil {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(stream.r.Context().Done()),
+		})
+	}
+	for {
+		chosen, elem, ok := reflect.Select(cases)
+		if chosen != 0 || !ok {
+			return
+		}
+		if !write(elem, true) {
+			return
+		}
+	}
+}
+
+// encode writes an element of a JSON array (indented like json.MarshalIndent) or an NDJSON line
+func (stream *jsonStream) encode(buf *bytes.Buffer, elem interface{}, first bool) error {
+	if stream.ndjson {
+		data, err := json.Marshal(elem)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+		return nil
+	}
+
+	data, err := json.MarshalIndent(elem, "\t", "\t")
+	if err != nil {
+		return err
+	}
+	if !first {
+		buf.WriteByte(',')
+	}
+	buf.WriteString("\n\t")
+	buf.Write(data)
+	return nil
+}
+
+func setDefaultContentType(w http.ResponseWriter, contentType string) {
+	if len(w.Header().Get("Content-Type")) == 0 {
+		w.Header().Set("Content-Type", contentType)
+	}
+}
@@ -0,0 +1,58 @@
+package beepboop
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONStream(t *testing.T) {
+	items := []map[string]int{{"n": 1}, {"n": 2}}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	(&View{StatusCode: http.StatusOK, Data: items}).renderAPIResponse(w, r)
+	var decoded []map[string]int
+	if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil || len(decoded) != 2 || decoded[1]["n"] != 2 {
+		t.Errorf("decoded %v, %v from %q", decoded, err, w.Body.String())
+	}
+
+	ch := make(chan int, 3)
+	ch <- 1
+	ch <- 2
+	ch <- 3
+	close(ch)
+	r.Header.Set("Accept", "application/x-ndjson")
+	w = httptest.NewRecorder()
+	(&View{StatusCode: http.StatusOK, Data: ch}).renderAPIResponse(w, r)
+	if body := w.Body.String(); body != "1\n2\n3\n" {
+		t.Errorf("NDJSON body = %q", body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestJSONStreamError(t *testing.T) {
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	items := []float64{1, math.Inf(1), 3}
+	w := httptest.NewRecorder()
+	(&View{StatusCode: http.StatusOK, Data: items}).renderAPIResponse(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := w.Body.String()
+	if strings.Contains(body, "unsupported") || strings.Contains(body, "3") {
+		t.Errorf("the stream continued after the error: %q", body)
+	}
+	if json.Valid([]byte(body)) {
+		t.Errorf("the failed stream is valid JSON: %q", body)
+	}
+	if !strings.Contains(logs.String(), "unsupported value") {
+		t.Errorf("error is not logged: %q", logs.String())
+	}
+}
@@ -1,8 +1,10 @@
 package beepboop
 
 import (
+	"fmt"
 	"html/template"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/Masterminds/sprig/v3"
@@ -10,39 +12,40 @@ import (
 
 var styleT = `
 a {
-	color: black;
+	color: var(--bb-text);
 	text-decoration: underline;
-	text-decoration-color: rgb(220, 53, 69);
-	-webkit-text-decoration-color: rgb(220, 53, 69);
+	text-decoration-color: var(--bb-accent);
+	-webkit-text-decoration-color: var(--bb-accent);
 }
 a:hover {
-	color: dimgrey;
+	color: var(--bb-muted);
 }
 input[type="text"], input[type="password"] {
 	border: 0;
 	outline: 0;
 	background: transparent;
-	border-bottom: 1px solid black;
+	border-bottom: 1px solid var(--bb-border);
+	color: var(--bb-text);
 	margin-bottom: 1rem;
 	min-width: 250px;
 }
 input[type="submit"], input[type="button"], button, .button {
-	border: 1px solid black;
+	border: 1px solid var(--bb-border);
 	border-radius: 5px;
-	background-color: whitesmoke;
+	background-color: var(--bb-button);
 	padding: 5px 10px;
 	margin: 10px 0;
-	color: black;
+	color: var(--bb-text);
 	text-decoration: none;
 	display: inline-block;
 	cursor: pointer;
 }
 input[type="submit"]:disabled, input[type="button"]:disabled, button:disabled, .button:disabled {
-	color: grey;
+	color: var(--bb-muted);
 }
 input[type="submit"]:not(:disabled):hover, input[type="button"]:not(:disabled):hover,
 button:not(:disabled):hover, .button:not(:disabled):hover {
-	background-color: lightsteelblue;
+	background-color: var(--bb-highlight);
 }
 table {
 	border-collapse: collapse;
@@ -54,15 +57,15 @@ td {
 	border: 1px solid transparent;
 }
 tr:nth-child(odd) > td {
-	background-color: #F0F0F0;
+	background-color: var(--bb-stripe);
 }
 tr:first-child > td {
 	font-weight: bold;
-	border-bottom: 1px solid black;
-	background-color: white;
+	border-bottom: 1px solid var(--bb-border);
+	background-color: var(--bb-panel);
 }
 tr:not(:first-child):hover > td {
-	background-color: lightsteelblue;
+	background-color: var(--bb-highlight);
 }
 tr:not(:first-child) > td:first-child {
 	border-radius: 10px 0 0 10px;
@@ -77,23 +80,33 @@ tr:not(:first-child) > td:only-child {
 	border: 0;
 }
 small {
-	color: dimgrey;
+	color: var(--bb-muted);
+}
+code, pre {
+	font-family: var(--bb-monospace);
+}
+nav.pagination > * {
+	display: inline-block;
+	padding: 0 0.25rem;
 }
 `
 
 var layoutT = `
 <!DOCTYPE html>
-<html>
+<html{{with .Lang}} lang="{{.}}"{{end}}{{with .ColorScheme}} data-color-scheme="{{.}}"{{end}}>
 	<head>
-		{{if .Title}}<title>{{.Title}}</title>{{end}}
+		{{if .Title}}<title>{{T .Title}}</title>{{end}}
 		<base href="{{.Base}}" />
 		{{range $name, $content := .Meta}}
 			<meta name="{{$name}}" content="{{$content}}" />
 		{{end}}
 		<link rel="icon" href="favicon.png" type="image/png" />
 		<style>
+			{{.Theme.CSS}}
 			body {
-				background-color: white;
+				background-color: var(--bb-background);
+				color: var(--bb-text);
+				font-family: var(--bb-font);
 			}
 			div.outer {
 				display: flex;
@@ -101,7 +114,7 @@ var layoutT = `
 				justify-content: center;
 			}
 			div.inner {
-				background-color: white;
+				background-color: var(--bb-panel);
 				padding: 1rem;
 				display: inline-flex;
 			}
@@ -116,13 +129,33 @@ var layoutT = `
 			@media screen and (min-width: 1200px) {
 				body {
 					margin: 1rem;
-					background-image: url("data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' width='4' height='4' viewBox='0 0 4 4'%3E%3Cpath fill='%23808080' fill-opacity='0.5' d='M1 3h1v1H1V3zm2-2h1v1H3V1z'%3E%3C/path%3E%3C/svg%3E");
+					background-image: var(--bb-pattern);
 				}
 				div.inner {
-					border: 1px solid black;
+					border: 1px solid var(--bb-border);
 					border-radius: 15px;
 				}
 			}
+			img.logo {
+				display: block;
+				max-height: 4rem;
+				margin-bottom: 1rem;
+			}
+			div.color-scheme-toggle {
+				position: absolute;
+				top: 0.5rem;
+				right: 0.5rem;
+			}
+			div.color-scheme-toggle a {
+				text-decoration: none;
+				font-size: 1.25rem;
+			}
+			a.to-dark {
+				display: var(--bb-to-dark);
+			}
+			a.to-light {
+				display: var(--bb-to-light);
+			}
 			{{template "style"}}
 		</style>
 		{{range .Stylesheets}}
@@ -131,20 +164,39 @@ var layoutT = `
 		{{range .Scripts}}
 			<script src="{{.}}"></script>
 		{{end}}
+		{{block "head" .}}{{end}}
 	</head>
 	<body>
+		{{if .Theme.ShowColorSchemeToggle}}
+			<div class="color-scheme-toggle">
+				<a class="to-dark" href="color-scheme?set=dark" title="Dark mode">&#9790;</a>
+				<a class="to-light" href="color-scheme?set=light" title="Light mode">&#9728;</a>
+			</div>
+		{{end}}
+		{{block "nav" .}}{{end}}
 		<div class="outer">
 			<div class="inner">
+				{{block "sidebar" .}}{{end}}
 				<div>
+					{{with .Theme.LogoURL}}
+						<a href="{{or $.Theme.LogoLink "/"}}"><img class="logo" src="{{.}}" alt="{{$.Theme.LogoAlt}}" /></a>
+					{{end}}
 					{{template "page" .Data}}
 				</div>
 			</div>
 		</div>
+		{{block "footer" .}}{{end}}
+		{{block "scripts" .}}{{end}}
 	</body>
 </html>
 `
 
 // Layout is used to give pages a uniform layout
+//
+// Besides "page" (which receives the view data), pages can override the
+// "style", "head", "nav", "sidebar", "footer" and "scripts" blocks of the
+// default layout by defining them in their content template. These blocks
+// receive the whole layout view (.Lang, .Title, .Meta, .Theme, .ColorScheme, .Data, etc).
 type Layout interface {
 	BindTemplate(pageTemplate string, stylesheets, scripts []string, meta map[string]string) (LayoutRenderer, error)
 }
@@ -153,10 +205,57 @@ type Layout interface {
 type LayoutRenderer func(w http.ResponseWriter, r *http.Request, title string, data interface{}, statusCode int)
 
 // DefaultLayout is razlink's default layout
-var DefaultLayout Layout = (*layout)(template.Must(template.New("layout").Funcs(sprig.FuncMap()).Funcs(TemplateFuncs).Parse(layoutT)))
+var DefaultLayout Layout = (*layout)(template.Must(newLayoutTemplate().Parse(layoutT)))
 
 type layout template.Template
 
+func newLayoutTemplate() *template.Template {
+	return template.New("layout").Funcs(sprig.FuncMap()).Funcs(TemplateFuncs)
+}
+
+// NewLayout creates a layout from a template that defines the "layout" skeleton
+// (which should contain {{template "page" .Data}}) and optional partials that
+// are shared between the pages using this layout
+func NewLayout(layoutTemplate string, partials ...string) (Layout, error) {
+	tmpl, err := newLayoutTemplate().Parse(layoutTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if err := parsePartials(tmpl, partials); err != nil {
+		return nil, err
+	}
+	return (*layout)(tmpl), nil
+}
+
+// ExtendLayout creates a layout that inherits the skeleton and blocks of its parent
+// and overrides the blocks defined in the given template
+func ExtendLayout(parent Layout, blocks string, partials ...string) (Layout, error) {
+	parentLayout, ok := parent.(*layout)
+	if !ok {
+		return nil, fmt.Errorf("layout %T can't be extended", parent)
+	}
+	tmpl, err := (*template.Template)(parentLayout).Clone()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmpl.New("blocks").Parse(blocks); err != nil {
+		return nil, err
+	}
+	if err := parsePartials(tmpl, partials); err != nil {
+		return nil, err
+	}
+	return (*layout)(tmpl), nil
+}
+
+func parsePartials(tmpl *template.Template, partials []string) error {
+	for i, partial := range partials {
+		if _, err := tmpl.New(fmt.Sprintf("partial%d", i)).Parse(partial); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // BindTemplate creates a layout renderer function from a page template
 func (l *layout) BindTemplate(pageTemplate string, stylesheets, scripts []string, meta map[string]string) (LayoutRenderer, error) {
 	cloneLayout, _ := (*template.Template)(l).Clone()
@@ -169,28 +268,74 @@ func (l *layout) BindTemplate(pageTemplate string, stylesheets, scripts []string
 		tmpl = template.Must(tmpl.New("style").Parse(styleT))
 	}
 
+	// tmpl is never executed, only its clones bound to the request being rendered
+	pool := newTemplatePool(tmpl)
+
 	return func(w http.ResponseWriter, r *http.Request, title string, data interface{}, statusCode int) {
+		localizer := getLocalizer(r)
 		view := struct {
+			Lang        string
 			Title       string
 			Base        string
 			Stylesheets []string
 			Scripts     []string
 			Meta        map[string]string
+			Theme       *Theme
+			ColorScheme string
 			Data        interface{}
 		}{
+			Lang:        localizer.Locale(),
 			Title:       title,
 			Base:        GetBase(r),
-			Stylesheets: stylesheets,
-			Scripts:     scripts,
+			Stylesheets: assetURLs(r, stylesheets),
+			Scripts:     assetURLs(r, scripts),
 			Meta:        meta,
+			Theme:       getTheme(r),
+			ColorScheme: GetColorScheme(r),
 			Data:        data,
 		}
 
+		// render into a buffer first so a failing template doesn't produce a half-written page
+		buf := getBuffer()
+		defer putBuffer(buf)
+		tmpl, err := pool.get(w, r)
+		if err != nil {
+			handleRenderError(w, r, err, data)
+			return
+		}
+		defer pool.put(tmpl)
+		if fragment := getFragment(r); len(fragment) > 0 {
+			if tmpl.Lookup(fragment) == nil {
+				err = fmt.Errorf("template: no fragment %q", fragment)
+			} else {
+				err = tmpl.ExecuteTemplate(buf, fragment, data)
+			}
+		} else {
+			err = tmpl.ExecuteTemplate(buf, "layout", &view)
+		}
+		if err != nil {
+			handleRenderError(w, r, err, data)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
 		w.WriteHeader(statusCode)
-		tmpl.ExecuteTemplate(w, "layout", &view)
+		w.Write(buf.Bytes())
 	}, nil
 }
 
+// assetURLs returns the fingerprinted URLs of the assets served by the server
+func assetURLs(r *http.Request, urls []string) []string {
+	pr := getPageRequest(r)
+	if pr == nil || len(urls) == 0 {
+		return urls
+	}
+	assetURLs := make([]string, len(urls))
+	for i, u := range urls {
+		assetURLs[i] = pr.Context.AssetURL(u)
+	}
+	return assetURLs
+}
+
 // GetBase returns the base target for relative URLs
 func GetBase(r *http.Request) string {
 	slashes := strings.Count(r.URL.Path, "/")
@@ -205,7 +350,7 @@ type ErrorRenderer func(w http.ResponseWriter, r *http.Request, errmsg string, e
 
 // GetErrorRenderer returns an ErrorRenderer using the given layout
 func GetErrorRenderer(layout Layout) ErrorRenderer {
-	renderer, _ := layout.BindTemplate("<strong>{{.}}</strong>", nil, nil, nil)
+	renderer, _ := layout.BindTemplate("<strong>{{T .}}</strong>", nil, nil, nil)
 	return func(w http.ResponseWriter, r *http.Request, errmsg string, errcode int) {
 		renderer(w, r, errmsg, errmsg, errcode)
 	}
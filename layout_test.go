@@ -0,0 +1,70 @@
+package beepboop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func renderLayout(t *testing.T, l Layout, pageTemplate string) string {
+	t.Helper()
+	render, err := l.BindTemplate(pageTemplate, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	render(w, httptest.NewRequest(http.MethodGet, "/", nil), "Title", "data", http.StatusOK)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	return w.Body.String()
+}
+
+func TestLayoutBlockOverrides(t *testing.T) {
+	base, err := NewLayout(`<nav>{{block "nav" .}}base nav{{end}}</nav>`+
+		`<main>{{template "page" .Data}}</main>`+
+		`<footer>{{block "footer" .}}base footer{{end}}</footer>`,
+		`{{define "greeting"}}hello {{.}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := ExtendLayout(base, `{{define "footer"}}child footer of {{.Title}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name         string
+		layout       Layout
+		pageTemplate string
+		expected     string
+	}{
+		{"defaults", base, `{{.}}`,
+			"<nav>base nav</nav><main>data</main><footer>base footer</footer>"},
+		{"page override", base, `{{define "nav"}}page nav{{end}}{{template "greeting" .}}`,
+			"<nav>page nav</nav><main>hello data</main><footer>base footer</footer>"},
+		{"extended layout", child, `{{.}}`,
+			"<nav>base nav</nav><main>data</main><footer>child footer of Title</footer>"},
+		{"page over extended layout", child, `{{define "footer"}}page footer{{end}}{{.}}`,
+			"<nav>base nav</nav><main>data</main><footer>page footer</footer>"},
+		// the overrides of other pages and the child layout don't leak into the parent
+		{"parent untouched", base, `{{.}}`,
+			"<nav>base nav</nav><main>data</main><footer>base footer</footer>"},
+	}
+	for _, tc := range cases {
+		if body := renderLayout(t, tc.layout, tc.pageTemplate); body != tc.expected {
+			t.Errorf("%s: %q, expected %q", tc.name, body, tc.expected)
+		}
+	}
+}
+
+func TestDefaultLayoutBlocks(t *testing.T) {
+	body := renderLayout(t, DefaultLayout, `{{define "head"}}<meta name="x-test">{{end}}`+
+		`{{define "footer"}}<p>custom footer</p>{{end}}{{.}}`)
+	for _, s := range []string{`<meta name="x-test">`, "<p>custom footer</p>", "data"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("%q missing from the default layout: %s", s, body)
+		}
+	}
+}
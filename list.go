@@ -0,0 +1,264 @@
+package beepboop
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPerPage is the page size of list queries that don't specify per_page
+var DefaultPerPage = 50
+
+// MaxPerPage is the largest page size clients can request
+var MaxPerPage = 500
+
+// ListQuery contains the pagination, sorting and filtering parameters of a list request.
+// Data sources can use them directly (see Offset and Limit) or let ApplyListQuery apply them to a slice.
+type ListQuery struct {
+	Page    int               // 1-based
+	PerPage int               // per_page
+	Sort    string            // sort (like "name" or "-name" for descending order)
+	Desc    bool              // order=desc
+	Filters map[string]string // values of the filter parameters by field name
+	request *http.Request
+}
+
+// Pagination is the pagination metadata of a list response
+type Pagination struct {
+	Page    int    `json:"page"`
+	PerPage int    `json:"per_page"`
+	Total   int    `json:"total"`
+	Pages   int    `json:"pages"`
+	First   string `json:"first,omitempty"`
+	Prev    string `json:"prev,omitempty"`
+	Next    string `json:"next,omitempty"`
+	Last    string `json:"last,omitempty"`
+}
+
+// ListPage is a page of items and its pagination metadata
+type ListPage[T any] struct {
+	Items      []T         `json:"items"`
+	Pagination *Pagination `json:"pagination"`
+}
+
+// ListQuery parses the page, per_page, sort, order and filter query parameters of the request.
+// Filters are query parameters named after fields (like "size") or mapped to them (like "type:PrimaryType").
+func (r *PageRequest) ListQuery(filters ...string) *ListQuery {
+	query := r.Request.URL.Query()
+	q := &ListQuery{
+		Page:    1,
+		PerPage: DefaultPerPage,
+		Sort:    query.Get("sort"),
+		Filters: make(map[string]string),
+		request: r.Request,
+	}
+	if page, err := strconv.Atoi(query.Get("page")); err == nil && page > 0 {
+		q.Page = page
+	}
+	if perPage, err := strconv.Atoi(query.Get("per_page")); err == nil && perPage > 0 {
+		q.PerPage = perPage
+	}
+	if q.PerPage > MaxPerPage {
+		q.PerPage = MaxPerPage
+	}
+	// the offset of the page must fit in an int
+	if maxPage := math.MaxInt / q.PerPage; q.Page > maxPage {
+		q.Page = maxPage
+	}
+	if strings.HasPrefix(q.Sort, "-") {
+		q.Sort, q.Desc = q.Sort[1:], true
+	}
+	switch strings.ToLower(query.Get("order")) {
+	case "desc":
+		q.Desc = true
+	case "asc":
+		q.Desc = false
+	}
+	for _, filter := range filters {
+		param, field := filter, filter
+		if i := strings.Index(filter, ":"); i != -1 {
+			param, field = filter[:i], filter[i+1:]
+		}
+		if value := query.Get(param); len(value) > 0 {
+			q.Filters[field] = value
+		}
+	}
+	return q
+}
+
+// Offset returns the index of the first item of the page (math.MaxInt if it doesn't fit in an int)
+func (q *ListQuery) Offset() int {
+	if q.Page <= 1 || q.PerPage <= 0 {
+		return 0
+	}
+	if q.Page-1 > math.MaxInt/q.PerPage {
+		return math.MaxInt
+	}
+	return (q.Page - 1) * q.PerPage
+}
+
+// Limit returns the maximum number of items on the page
+func (q *ListQuery) Limit() int {
+	return q.PerPage
+}
+
+// Pagination returns the pagination metadata of the page if the list has total items
+func (q *ListQuery) Pagination(total int) *Pagination {
+	pages := (total + q.PerPage - 1) / q.PerPage
+	if pages < 1 {
+		pages = 1
+	}
+	p := &Pagination{
+		Page:    q.Page,
+		PerPage: q.PerPage,
+		Total:   total,
+		Pages:   pages,
+	}
+	link := func(page int) string {
+		return SetQuery(q.request, "page", strconv.Itoa(page))
+	}
+	p.First, p.Last = link(1), link(pages)
+	if q.Page > 1 {
+		p.Prev = link(q.Page - 1)
+		if q.Page > pages {
+			p.Prev = p.Last
+		}
+	}
+	if q.Page < pages {
+		p.Next = link(q.Page + 1)
+	}
+	return p
+}
+
+// WithPagination adds the Link (first, prev, next, last) and X-Total-Count headers to the view
+func WithPagination(p *Pagination) ViewOption {
+	return func(view *View) {
+		var links []string
+		for _, link := range []struct{ rel, url string }{
+			{"first", p.First}, {"prev", p.Prev}, {"next", p.Next}, {"last", p.Last},
+		} {
+			if len(link.url) > 0 {
+				links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, link.url, link.rel))
+			}
+		}
+		if len(links) > 0 {
+			WithHeader("Link", strings.Join(links, ", "))(view)
+		}
+		WithHeader("X-Total-Count", strconv.Itoa(p.Total))(view)
+	}
+}
+
+// ApplyListQuery filters, sorts and paginates items (structs or pointers to structs
+// whose fields are matched by name or JSON name, case insensitively)
+func ApplyListQuery[T any](q *ListQuery, items []T) *ListPage[T] {
+	filtered := make([]T, 0, len(items))
+	for _, item := range items {
+		if q.matches(reflect.ValueOf(item)) {
+			filtered = append(filtered, item)
+		}
+	}
+	if len(q.Sort) > 0 {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			a := listField(reflect.ValueOf(filtered[i]), q.Sort)
+			b := listField(reflect.ValueOf(filtered[j]), q.Sort)
+			if q.Desc {
+				return compareValues(b, a) < 0
+			}
+			return compareValues(a, b) < 0
+		})
+	}
+
+	page := &ListPage[T]{
+		Items:      []T{},
+		Pagination: q.Pagination(len(filtered)),
+	}
+	if q.Page >= 1 && q.Page-1 < page.Pagination.Pages {
+		offset := q.Offset()
+		end := len(filtered)
+		if end-offset > q.PerPage {
+			end = offset + q.PerPage
+		}
+		page.Items = filtered[offset:end]
+	}
+	return page
+}
+
+func (q *ListQuery) matches(item reflect.Value) bool {
+	for field, value := range q.Filters {
+		v := listField(item, field)
+		if !v.IsValid() || !strings.EqualFold(fmt.Sprint(v.Interface()), value) {
+			return false
+		}
+	}
+	return true
+}
+
+// listField returns the exported field of a struct (or pointer to struct) by name or JSON name
+func listField(v reflect.Value, name string) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if strings.EqualFold(field.Name, name) || (len(jsonName) > 0 && strings.EqualFold(jsonName, name)) {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// compareValues compares numbers, strings (case insensitively), bools and times
+func compareValues(a, b reflect.Value) int {
+	switch {
+	case !a.IsValid() && !b.IsValid():
+		return 0
+	case !a.IsValid():
+		return -1
+	case !b.IsValid():
+		return 1
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareOrdered(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return compareOrdered(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(a.Float(), b.Float())
+	case reflect.String:
+		return compareOrdered(strings.ToLower(a.String()), strings.ToLower(b.String()))
+	case reflect.Bool:
+		return compareOrdered(strconv.FormatBool(a.Bool()), strconv.FormatBool(b.Bool()))
+	}
+	if ta, ok := a.Interface().(time.Time); ok {
+		if tb, ok := b.Interface().(time.Time); ok {
+			return compareOrdered(ta.UnixNano(), tb.UnixNano())
+		}
+	}
+	return compareOrdered(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+}
+
+func compareOrdered[T int64 | uint64 | float64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package beepboop
+
+import (
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+type listTestItem struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+func TestApplyListQueryPages(t *testing.T) {
+	items := make([]listTestItem, 5)
+	for i := range items {
+		items[i] = listTestItem{Name: "item" + strconv.Itoa(i), Size: i}
+	}
+	maxPage := strconv.Itoa(math.MaxInt)
+	cases := []struct {
+		query string
+		page  int
+		names []string
+	}{
+		{"", 1, []string{"item0", "item1", "item2", "item3", "item4"}},
+		{"?page=1&per_page=2", 1, []string{"item0", "item1"}},
+		{"?page=3&per_page=2", 3, []string{"item4"}},
+		{"?page=4&per_page=2", 4, nil},
+		{"?page=0&per_page=2", 1, []string{"item0", "item1"}},
+		{"?page=-1&per_page=2", 1, []string{"item0", "item1"}},
+		{"?page=4611686018427387905&per_page=2", math.MaxInt / 2, nil},
+		{"?page=" + maxPage + "&per_page=1", math.MaxInt, nil},
+		{"?page=" + maxPage + "&per_page=500", math.MaxInt / 500, nil},
+		{"?page=" + maxPage + "0&per_page=2", 1, []string{"item0", "item1"}},
+		{"?page=2&per_page=100000", 2, nil},
+		{"?sort=-size&page=2&per_page=3", 2, []string{"item1", "item0"}},
+	}
+	for _, tc := range cases {
+		pr := &PageRequest{Request: httptest.NewRequest("GET", "/list"+tc.query, nil)}
+		q := pr.ListQuery()
+		if q.Page != tc.page {
+			t.Errorf("%s: page %d, expected %d", tc.query, q.Page, tc.page)
+		}
+		if offset := q.Offset(); offset < 0 {
+			t.Errorf("%s: negative offset %d", tc.query, offset)
+		}
+		page := ApplyListQuery(q, items)
+		var names []string
+		for _, item := range page.Items {
+			names = append(names, item.Name)
+		}
+		if fmt.Sprint(names) != fmt.Sprint(tc.names) {
+			t.Errorf("%s: items %v, expected %v", tc.query, names, tc.names)
+		}
+	}
+}
+
+func TestListQueryOffset(t *testing.T) {
+	cases := []struct {
+		page, perPage, offset int
+	}{
+		{1, 50, 0},
+		{3, 50, 100},
+		{0, 50, 0},
+		{math.MaxInt, 2, math.MaxInt},
+		{math.MaxInt/2 + 1, 2, math.MaxInt - 1},
+		{math.MaxInt/2 + 2, 2, math.MaxInt},
+	}
+	for _, tc := range cases {
+		q := &ListQuery{Page: tc.page, PerPage: tc.perPage}
+		if offset := q.Offset(); offset != tc.offset {
+			t.Errorf("page %d, per page %d: offset %d, expected %d", tc.page, tc.perPage, offset, tc.offset)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package beepboop
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/razzie/geoip-server/geoip"
+)
+
+// LogDropPolicy decides what happens to a request log when the queue is full
+type LogDropPolicy int
+
+// log drop policies
+const (
+	// LogSkipEnrichment logs the request right away without location and hostname data
+	LogSkipEnrichment LogDropPolicy = iota
+	// LogDrop drops the request log entirely
+	LogDrop
+)
+
+// LogPipelineStats contains the counters of a LogPipeline
+type LogPipelineStats struct {
+	Queued     int
+	Processed  uint64
+	Unenriched uint64
+	Dropped    uint64
+}
+
+// LogPipeline enriches request logs with location and hostname data
+// using a fixed number of workers and a bounded queue
+type LogPipeline struct {
+	Timeout    time.Duration
+	DropPolicy LogDropPolicy
+	queue      chan *logEntry
+	wg         sync.WaitGroup
+	mtx        sync.RWMutex
+	closed     bool
+	processed  uint64
+	unenriched uint64
+	dropped    uint64
+}
+
+type logEntry struct {
+	ctx         context.Context
+	logger      *log.Logger
+	geoipClient geoip.Client
+	db          *DB
+	cache       *IPInfoCache
+	ip          string
+	head        string
+	tail        string
+}
+
+// NewLogPipeline returns a new LogPipeline
+func NewLogPipeline(workers, queueSize int) *LogPipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &LogPipeline{
+		Timeout: time.Second * 3,
+		queue:   make(chan *logEntry, queueSize),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Stats returns the current counters of the pipeline
+func (p *LogPipeline) Stats() LogPipelineStats {
+	return LogPipelineStats{
+		Queued:     len(p.queue),
+		Processed:  atomic.LoadUint64(&p.processed),
+		Unenriched: atomic.LoadUint64(&p.unenriched),
+		Dropped:    atomic.LoadUint64(&p.dropped),
+	}
+}
+
+// Close stops accepting new entries and waits until the queued ones are written
+func (p *LogPipeline) Close() error {
+	p.mtx.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mtx.Unlock()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *LogPipeline) enqueue(entry *logEntry) {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	if !p.closed {
+		select {
+		case p.queue <- entry:
+			return
+		default:
+		}
+	}
+	if p.DropPolicy == LogDrop {
+		atomic.AddUint64(&p.dropped, 1)
+		return
+	}
+	atomic.AddUint64(&p.unenriched, 1)
+	entry.write("")
+}
+
+func (p *LogPipeline) work() {
+	defer p.wg.Done()
+	for entry := range p.queue {
+		ctx, cancel := context.WithTimeout(entry.ctx, p.Timeout)
+		info, _ := entry.cache.Lookup(ctx, entry.ip, entry.geoipClient, entry.db)
+		cancel()
+		entry.write(info.String())
+		atomic.AddUint64(&p.processed, 1)
+	}
+}
+
+func (entry *logEntry) write(info string) {
+	msg := entry.head
+	if len(info) > 0 {
+		msg += ", " + info
+	}
+	entry.logger.Print(msg + entry.tail)
+}
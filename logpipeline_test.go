@@ -0,0 +1,178 @@
+package beepboop
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/razzie/geoip-server/geoip"
+)
+
+// testGeoIPClient returns the same location for every IP and counts the lookups
+type testGeoIPClient struct {
+	mtx   sync.Mutex
+	calls int
+	loc   *geoip.Location
+}
+
+func (c *testGeoIPClient) Provider() string {
+	return "test"
+}
+
+func (c *testGeoIPClient) GetLocation(ctx context.Context, hostname string) (*geoip.Location, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.calls++
+	if c.loc == nil {
+		return nil, nil
+	}
+	loc := *c.loc
+	loc.IP = hostname
+	return &loc, nil
+}
+
+// blockingSink is a log output whose writes block until it's released
+type blockingSink struct {
+	mtx     sync.Mutex
+	lines   []string
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+}
+
+func (s *blockingSink) Write(p []byte) (int, error) {
+	s.once.Do(func() { close(s.started) })
+	<-s.release
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.lines = append(s.lines, strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+func (s *blockingSink) getLines() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+func newTestLogEntry(logger *log.Logger, client geoip.Client, head string) *logEntry {
+	return &logEntry{
+		ctx:         context.Background(),
+		logger:      logger,
+		geoipClient: client,
+		ip:          "192.0.2.1",
+		head:        head,
+	}
+}
+
+func TestLogPipelineQueue(t *testing.T) {
+	for _, policy := range []LogDropPolicy{LogDrop, LogSkipEnrichment} {
+		sink := newBlockingSink()
+		logger := log.New(sink, "", 0)
+		client := &testGeoIPClient{loc: &geoip.Location{Country: "Hungary"}}
+		p := NewLogPipeline(1, 2)
+		p.DropPolicy = policy
+
+		// the worker blocks on writing the first entry, the next two fill the queue
+		p.enqueue(newTestLogEntry(logger, client, "1"))
+		<-sink.started
+		p.enqueue(newTestLogEntry(logger, client, "2"))
+		p.enqueue(newTestLogEntry(logger, client, "3"))
+		if queued := p.Stats().Queued; queued != 2 {
+			t.Fatalf("%d queued entries, expected 2", queued)
+		}
+
+		overflow := make(chan struct{})
+		go func() {
+			p.enqueue(newTestLogEntry(logger, client, "4"))
+			close(overflow)
+		}()
+		if policy == LogDrop {
+			// dropping doesn't wait for the sink
+			<-overflow
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			p.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+			t.Fatal("Close returned before the queue was flushed")
+		case <-time.After(20 * time.Millisecond):
+		}
+		close(sink.release)
+		<-closed
+		<-overflow
+
+		lines := sink.getLines()
+		expected := []string{"1, Hungary", "2, Hungary", "3, Hungary"}
+		stats := LogPipelineStats{Processed: 3, Dropped: 1}
+		if policy == LogSkipEnrichment {
+			// the overflowing entry is written without enrichment, racing with the worker
+			expected = append(expected, "4")
+			stats = LogPipelineStats{Processed: 3, Unenriched: 1}
+			for i, line := range lines {
+				if line == "4" {
+					lines = append(lines[:i:i], lines[i+1:]...)
+					lines = append(lines, "4")
+					break
+				}
+			}
+		}
+		if strings.Join(lines, "|") != strings.Join(expected, "|") {
+			t.Errorf("policy %d: lines = %q, expected %q", policy, lines, expected)
+		}
+		if p.Stats() != stats {
+			t.Errorf("policy %d: stats = %+v, expected %+v", policy, p.Stats(), stats)
+		}
+	}
+}
+
+func TestLogPipelineClosed(t *testing.T) {
+	var out strings.Builder
+	p := NewLogPipeline(2, 8)
+	p.Close()
+	p.enqueue(newTestLogEntry(log.New(&out, "", 0), nil, "late"))
+	if out.String() != "late\n" || p.Stats().Unenriched != 1 {
+		t.Errorf("entry after Close: %q, %+v", out.String(), p.Stats())
+	}
+}
+
+func TestIPInfoCacheLookup(t *testing.T) {
+	client := &testGeoIPClient{loc: &geoip.Location{Country: "Hungary", City: "Budapest"}}
+	c := NewIPInfoCache(1, time.Minute)
+	for i := 0; i < 3; i++ {
+		info, err := c.Lookup(context.Background(), "192.0.2.1", client, nil)
+		if err != nil || info.String() != "Budapest (Hungary)" {
+			t.Fatalf("Lookup = %v, %v", info, err)
+		}
+	}
+	if client.calls != 1 {
+		t.Errorf("%d GeoIP lookups, expected 1", client.calls)
+	}
+
+	// the least recently used IP is evicted
+	c.Lookup(context.Background(), "192.0.2.2", client, nil)
+	c.Lookup(context.Background(), "192.0.2.1", client, nil)
+	if client.calls != 3 {
+		t.Errorf("%d GeoIP lookups, expected 3", client.calls)
+	}
+
+	c.Remove("192.0.2.1")
+	c.Lookup(context.Background(), "192.0.2.1", client, nil)
+	if client.calls != 4 {
+		t.Errorf("%d GeoIP lookups after Remove, expected 4", client.calls)
+	}
+}
@@ -0,0 +1,343 @@
+package beepboop
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+)
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// ErrInvalidMMDB is returned when a file is not a valid MaxMind DB
+var ErrInvalidMMDB = fmt.Errorf("invalid MaxMind DB file")
+
+// mmdbReader reads the MaxMind DB format (https://maxmind.github.io/MaxMind-DB/)
+type mmdbReader struct {
+	buf        []byte
+	data       []byte
+	metadata   map[string]interface{}
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+func newMMDBReader(buf []byte) (*mmdbReader, error) {
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i == -1 {
+		return nil, ErrInvalidMMDB
+	}
+	metaDecoder := mmdbDecoder{buf: buf[i+len(mmdbMetadataMarker):]}
+	meta, _, err := metaDecoder.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidMMDB
+	}
+
+	r := &mmdbReader{
+		buf:        buf,
+		metadata:   metadata,
+		nodeCount:  mmdbUint(metadata["node_count"]),
+		recordSize: mmdbUint(metadata["record_size"]),
+		ipVersion:  mmdbUint(metadata["ip_version"]),
+	}
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported MaxMind DB record size: %d", r.recordSize)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, ErrInvalidMMDB
+	}
+	r.data = buf[treeSize+16 : i]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < r.nodeCount; j++ {
+			node = r.readNode(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// DatabaseType returns the type of the database, like GeoLite2-City
+func (r *mmdbReader) DatabaseType() string {
+	typ, _ := r.metadata["database_type"].(string)
+	return typ
+}
+
+// Lookup returns the record of the given IP or nil if it is not found
+func (r *mmdbReader) Lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	bitCount := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bitCount = 32
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		// IPv6 addresses are not in IPv4-only databases
+		return nil, nil
+	}
+
+	for i := 0; i < bitCount && node < r.nodeCount; i++ {
+		bit := (ip[i>>3] >> (7 - uint(i&7))) & 1
+		node = r.readNode(node, uint(bit))
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, ErrInvalidMMDB
+	}
+
+	offset := node - r.nodeCount - 16
+	decoder := mmdbDecoder{buf: r.data}
+	value, _, err := decoder.decode(offset)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+func (r *mmdbReader) readNode(node, bit uint) uint {
+	b := r.buf
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return uint(b[off+3]&0xf0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return uint(b[off+3]&0x0f)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+type mmdbDecoder struct {
+	buf []byte
+}
+
+// mmdbMaxDepth limits the nesting of maps and arrays (and the pointers within them)
+// so corrupt databases with pointer loops can't overflow the stack
+const mmdbMaxDepth = 64
+
+// mmdb data types
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+func (d *mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d *mmdbDecoder) decodeDepth(offset uint, depth int) (interface{}, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, ErrInvalidMMDB
+	}
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, ErrInvalidMMDB
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		ptr, next, err := d.decodePointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// pointers to pointers are not allowed by the spec
+		if ptr >= uint(len(d.buf)) || d.buf[ptr]>>5 == mmdbPointer {
+			return nil, 0, ErrInvalidMMDB
+		}
+		value, _, err := d.decodeDepth(ptr, depth+1)
+		return value, next, err
+	}
+
+	if typ == mmdbExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, ErrInvalidMMDB
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, ErrInvalidMMDB
+		}
+		var extra uint
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decodeDepth(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrInvalidMMDB
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbEndMarker, mmdbContainer:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, ErrInvalidMMDB
+	}
+	raw := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case mmdbString:
+		return string(raw), next, nil
+	case mmdbBytes:
+		return append([]byte(nil), raw...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidMMDB
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidMMDB
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		var v uint64
+		for _, b := range raw {
+			v = v<<8 | uint64(b)
+		}
+		return v, next, nil
+	case mmdbInt32:
+		var v uint32
+		for _, b := range raw {
+			v = v<<8 | uint32(b)
+		}
+		return int64(int32(v)), next, nil
+	case mmdbUint128:
+		return new(big.Int).SetBytes(raw), next, nil
+	}
+	return nil, 0, fmt.Errorf("unknown MaxMind DB data type: %d", typ)
+}
+
+func (d *mmdbDecoder) decodePointer(ctrl byte, offset uint) (uint, uint, error) {
+	ss := uint(ctrl>>3) & 3
+	n := ss + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, ErrInvalidMMDB
+	}
+	var ptr uint
+	if ss < 3 {
+		ptr = uint(ctrl & 7)
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		ptr = ptr<<8 | uint(b)
+	}
+	switch ss {
+	case 1:
+		ptr += 2048
+	case 2:
+		ptr += 526336
+	}
+	return ptr, offset + n, nil
+}
+
+func mmdbUint(v interface{}) uint {
+	switch v := v.(type) {
+	case uint64:
+		return uint(v)
+	case int64:
+		return uint(v)
+	}
+	return 0
+}
+
+// mmdbPath walks nested maps and arrays of a record
+func mmdbPath(v interface{}, path ...interface{}) interface{} {
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[p]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || p >= len(a) {
+				return nil
+			}
+			v = a[p]
+		}
+	}
+	return v
+}
@@ -0,0 +1,287 @@
+package beepboop
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// mmdbFixture generates small MaxMind DB files with 24-bit records
+type mmdbFixture struct {
+	ipVersion uint64
+	dbType    string
+	root      *mmdbFixtureNode
+	data      bytes.Buffer
+}
+
+type mmdbFixtureNode struct {
+	children [2]*mmdbFixtureNode
+	data     [2]int // data offset + 1 of the records (0 if empty)
+	index    int
+}
+
+func newMMDBFixture(ipVersion uint64, dbType string) *mmdbFixture {
+	return &mmdbFixture{
+		ipVersion: ipVersion,
+		dbType:    dbType,
+		root:      &mmdbFixtureNode{},
+	}
+}
+
+// insert adds a network with its record (IPv4 networks of IPv6 databases go under ::/96)
+func (f *mmdbFixture) insert(cidr string, record interface{}) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	ip := network.IP
+	ones, _ := network.Mask.Size()
+	if f.ipVersion == 6 {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = append(make(net.IP, 12), ip4...)
+			ones += 96
+		}
+	}
+	offset := f.data.Len()
+	f.data.Write(mmdbEncode(record))
+
+	node := f.root
+	for i := 0; i < ones; i++ {
+		bit := (ip[i>>3] >> (7 - uint(i&7))) & 1
+		if i == ones-1 {
+			node.data[bit] = offset + 1
+			break
+		}
+		if node.children[bit] == nil {
+			node.children[bit] = &mmdbFixtureNode{}
+		}
+		node = node.children[bit]
+	}
+}
+
+func (f *mmdbFixture) bytes() []byte {
+	var nodes []*mmdbFixtureNode
+	var walk func(node *mmdbFixtureNode)
+	walk = func(node *mmdbFixtureNode) {
+		node.index = len(nodes)
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil {
+				walk(child)
+			}
+		}
+	}
+	walk(f.root)
+
+	var buf bytes.Buffer
+	nodeCount := len(nodes)
+	for _, node := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := nodeCount
+			if node.children[bit] != nil {
+				record = node.children[bit].index
+			} else if node.data[bit] > 0 {
+				record = nodeCount + 16 + node.data[bit] - 1
+			}
+			buf.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(f.data.Bytes())
+	buf.Write(mmdbMetadataMarker)
+	buf.Write(mmdbEncode(map[string]interface{}{
+		"node_count":    uint64(nodeCount),
+		"record_size":   uint64(24),
+		"ip_version":    f.ipVersion,
+		"database_type": f.dbType,
+	}))
+	return buf.Bytes()
+}
+
+func (f *mmdbFixture) write(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), f.dbType+".mmdb")
+	if err := os.WriteFile(path, f.bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mmdbControl(typ int, size int) []byte {
+	var ctrl []byte
+	switch {
+	case size < 29:
+		ctrl = []byte{byte(size)}
+	case size < 285:
+		ctrl = []byte{29, byte(size - 29)}
+	default:
+		panic("value too large for the fixture")
+	}
+	if typ <= 7 {
+		ctrl[0] |= byte(typ) << 5
+		return ctrl
+	}
+	return append([]byte{ctrl[0]}, append([]byte{byte(typ - 7)}, ctrl[1:]...)...)
+}
+
+// mmdbEncode encodes maps, arrays, strings and unsigned integers in the MaxMind DB data format
+func mmdbEncode(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return append(mmdbControl(mmdbString, len(v)), v...)
+	case uint64:
+		var b []byte
+		for ; v > 0; v >>= 8 {
+			b = append([]byte{byte(v)}, b...)
+		}
+		return append(mmdbControl(mmdbUint64, len(b)), b...)
+	case []interface{}:
+		buf := mmdbControl(mmdbArray, len(v))
+		for _, elem := range v {
+			buf = append(buf, mmdbEncode(elem)...)
+		}
+		return buf
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf := mmdbControl(mmdbMap, len(v))
+		for _, key := range keys {
+			buf = append(buf, mmdbEncode(key)...)
+			buf = append(buf, mmdbEncode(v[key])...)
+		}
+		return buf
+	}
+	panic("unsupported type")
+}
+
+func mmdbNames(en string) map[string]interface{} {
+	return map[string]interface{}{"names": map[string]interface{}{"en": en}}
+}
+
+func TestMMDBClient(t *testing.T) {
+	city := newMMDBFixture(4, "Test-City")
+	city.insert("1.2.3.0/24", map[string]interface{}{
+		"continent": map[string]interface{}{"code": "EU"},
+		"country":   map[string]interface{}{"iso_code": "HU", "names": map[string]interface{}{"en": "Hungary"}},
+		"city":      mmdbNames("Budapest"),
+		"subdivisions": []interface{}{
+			map[string]interface{}{"iso_code": "BU", "names": map[string]interface{}{"en": "Budapest"}},
+		},
+		"location": map[string]interface{}{"time_zone": "Europe/Budapest"},
+	})
+	asn := newMMDBFixture(6, "Test-ASN")
+	asn.insert("1.2.0.0/16", map[string]interface{}{
+		"autonomous_system_number":       uint64(64500),
+		"autonomous_system_organization": "Example Networks",
+	})
+	asn.insert("2001:db8::/32", map[string]interface{}{
+		"autonomous_system_number":       uint64(64501),
+		"autonomous_system_organization": "Documentation",
+	})
+
+	client, err := OpenMMDB(0, city.write(t), asn.write(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	rec, err := client.Lookup(context.Background(), "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.CountryCode != "HU" || rec.Country != "Hungary" || rec.City != "Budapest" || rec.ContinentCode != "EU" ||
+		rec.RegionCode != "BU" || rec.TimeZone != "Europe/Budapest" {
+		t.Errorf("unexpected location: %+v", rec)
+	}
+	if rec.ASN != 64500 || rec.ASOrganization != "Example Networks" || rec.ISP != "Example Networks" {
+		t.Errorf("unexpected ASN: %+v", rec)
+	}
+
+	// the IPv4-only city database has no answer, the ASN database does
+	rec, err = client.Lookup(context.Background(), "2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.ASN != 64501 || len(rec.CountryCode) > 0 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+
+	rec, err = client.Lookup(context.Background(), "5.6.7.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.ASN != 0 || len(rec.CountryCode) > 0 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
+
+func TestMMDBPointerLoop(t *testing.T) {
+	for name, data := range map[string][]byte{
+		// a map of one key whose value points back to the map
+		"self reference": append(append(mmdbControl(mmdbMap, 1), mmdbEncode("k")...), 0x20, 0x00),
+		// a pointer to a pointer
+		"pointer to pointer": {0x20, 0x02, 0x20, 0x00},
+	} {
+		d := mmdbDecoder{buf: data}
+		if _, _, err := d.decode(0); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	d := mmdbDecoder{buf: append(append(mmdbControl(mmdbMap, 1), mmdbEncode("k")...), 0x20, 0x05)}
+	d.buf = append(d.buf, mmdbEncode("v")...)
+	value, _, err := d.decode(0)
+	if err != nil || mmdbPath(value, "k") != "v" {
+		t.Errorf("pointer: %v, %v", value, err)
+	}
+}
+
+func TestMMDBInvalid(t *testing.T) {
+	if _, err := newMMDBReader([]byte("not a database")); err != ErrInvalidMMDB {
+		t.Errorf("expected ErrInvalidMMDB, got %v", err)
+	}
+	// the search tree doesn't fit in the file
+	buf := newMMDBFixture(4, "Test").bytes()
+	buf = append(buf[:0:0], buf[6:]...)
+	if _, err := newMMDBReader(buf); err != ErrInvalidMMDB {
+		t.Errorf("expected ErrInvalidMMDB, got %v", err)
+	}
+}
+
+func TestIPInfoLookupFallback(t *testing.T) {
+	city := newMMDBFixture(4, "Test-City")
+	city.insert("1.2.3.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "HU", "names": map[string]interface{}{"en": "Hungary"}},
+	})
+	client, err := OpenMMDB(0, city.write(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reverseLookups []string
+	defer func(orig func(context.Context, string) ([]string, error)) { lookupAddr = orig }(lookupAddr)
+	lookupAddr = func(ctx context.Context, ip string) ([]string, error) {
+		reverseLookups = append(reverseLookups, ip)
+		return []string{"host.example.com."}, nil
+	}
+
+	info, err := lookupIPInfo(context.Background(), "1.2.3.4", client)
+	if err != nil || info.String() != "Hungary" || len(reverseLookups) != 0 {
+		t.Errorf("MMDB hit: %q, %v, reverse lookups: %q", info, err, reverseLookups)
+	}
+
+	// a miss in the database falls through to reverse DNS
+	info, err = lookupIPInfo(context.Background(), "5.6.7.8", client)
+	if err != nil || info.Location != nil || info.String() != "host.example.com." {
+		t.Errorf("MMDB miss: %q, %v", info, err)
+	}
+	if len(reverseLookups) != 1 || reverseLookups[0] != "5.6.7.8" {
+		t.Errorf("reverse lookups: %q", reverseLookups)
+	}
+}
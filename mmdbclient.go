@@ -0,0 +1,210 @@
+package beepboop
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/razzie/geoip-server/geoip"
+)
+
+// GeoIPRecord is a geoip.Location extended with continent and ASN data
+type GeoIPRecord struct {
+	geoip.Location
+	ContinentCode  string `json:"continent_code,omitempty"`
+	ASN            uint   `json:"asn,omitempty"`
+	ASOrganization string `json:"as_org,omitempty"`
+}
+
+// MMDBClient is a geoip.Client that reads local MaxMind DB files
+// (country, city and ASN databases) and reloads them when they change
+type MMDBClient struct {
+	Logger    *log.Logger
+	paths     []string
+	mtx       sync.RWMutex
+	readers   []*mmdbReader
+	modTimes  []time.Time
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenMMDB opens the given MaxMind DB files and checks them for changes
+// in every reloadInterval (hot reload is disabled if reloadInterval is 0)
+func OpenMMDB(reloadInterval time.Duration, paths ...string) (*MMDBClient, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no MaxMind DB files given")
+	}
+	c := &MMDBClient{
+		paths:    paths,
+		readers:  make([]*mmdbReader, len(paths)),
+		modTimes: make([]time.Time, len(paths)),
+		done:     make(chan struct{}),
+	}
+	for i := range paths {
+		if err := c.load(i); err != nil {
+			return nil, err
+		}
+	}
+	if reloadInterval > 0 {
+		go c.watch(reloadInterval)
+	}
+	return c, nil
+}
+
+// Provider returns the name of the loaded databases
+func (c *MMDBClient) Provider() string {
+	return "mmdb"
+}
+
+// GetLocation returns the location of an IP address or hostname
+func (c *MMDBClient) GetLocation(ctx context.Context, hostname string) (*geoip.Location, error) {
+	rec, err := c.Lookup(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	return &rec.Location, nil
+}
+
+// Lookup returns the location, continent and ASN of an IP address or hostname
+func (c *MMDBClient) Lookup(ctx context.Context, hostname string) (*GeoIPRecord, error) {
+	ip := net.ParseIP(hostname)
+	if ip == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, hostname)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("no IP address found for %s", hostname)
+		}
+		ip = addrs[0].IP
+	}
+
+	rec := &GeoIPRecord{
+		Location: geoip.Location{
+			IP:     ip.String(),
+			Source: c.Provider(),
+		},
+	}
+	if hostname != rec.IP {
+		rec.Hostname = hostname
+	}
+
+	// a database failing to look up the IP doesn't stop the others from answering
+	var lookupErr error
+	found := false
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	for _, r := range c.readers {
+		values, err := r.Lookup(ip)
+		if err != nil {
+			if lookupErr == nil {
+				lookupErr = fmt.Errorf("%s: %v", r.DatabaseType(), err)
+			}
+			continue
+		}
+		if values != nil {
+			rec.fill(values)
+			found = true
+		}
+	}
+	if !found && lookupErr != nil {
+		return nil, lookupErr
+	}
+	return rec, nil
+}
+
+// Reload reloads the database files that have changed since they were loaded
+func (c *MMDBClient) Reload() error {
+	for i, path := range c.paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		c.mtx.RLock()
+		modTime := c.modTimes[i]
+		c.mtx.RUnlock()
+		if fi.ModTime().Equal(modTime) {
+			continue
+		}
+		if err := c.load(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops watching the database files
+func (c *MMDBClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
+
+func (c *MMDBClient) load(i int) error {
+	fi, err := os.Stat(c.paths[i])
+	if err != nil {
+		return err
+	}
+	buf, err := ioutil.ReadFile(c.paths[i])
+	if err != nil {
+		return err
+	}
+	r, err := newMMDBReader(buf)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(c.paths[i]), err)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.readers[i] = r
+	c.modTimes[i] = fi.ModTime()
+	return nil
+}
+
+func (c *MMDBClient) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Reload(); err != nil && c.Logger != nil {
+				c.Logger.Print("failed to reload MaxMind DB: ", err)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (rec *GeoIPRecord) fill(values map[string]interface{}) {
+	str := func(path ...interface{}) string {
+		s, _ := mmdbPath(values, path...).(string)
+		return s
+	}
+	setIfEmpty := func(dst *string, value string) {
+		if len(*dst) == 0 {
+			*dst = value
+		}
+	}
+
+	setIfEmpty(&rec.ContinentCode, str("continent", "code"))
+	setIfEmpty(&rec.CountryCode, str("country", "iso_code"))
+	setIfEmpty(&rec.Country, str("country", "names", "en"))
+	setIfEmpty(&rec.RegionCode, str("subdivisions", 0, "iso_code"))
+	setIfEmpty(&rec.Region, str("subdivisions", 0, "names", "en"))
+	setIfEmpty(&rec.City, str("city", "names", "en"))
+	setIfEmpty(&rec.TimeZone, str("location", "time_zone"))
+	setIfEmpty(&rec.ASOrganization, str("autonomous_system_organization"))
+	setIfEmpty(&rec.ISP, str("isp"))
+	setIfEmpty(&rec.ISP, rec.ASOrganization)
+	if rec.ASN == 0 {
+		rec.ASN = mmdbUint(values["autonomous_system_number"])
+	}
+}
@@ -0,0 +1,140 @@
+package beepboop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OTLPExporter exports spans to an OpenTelemetry collector using OTLP/HTTP with JSON encoding
+type OTLPExporter struct {
+	Endpoint string // collector base URL, like http://localhost:4318
+	Header   http.Header
+	Client   *http.Client
+}
+
+// NewOTLPExporter returns a new OTLPExporter
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint: endpoint,
+		Header:   make(http.Header),
+		Client:   http.DefaultClient,
+	}
+}
+
+// ExportSpans implements SpanExporter
+func (e *OTLPExporter) ExportSpans(ctx context.Context, serviceName string, spans []*Span) error {
+	data, err := json.Marshal(otlpTraceRequest(serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(e.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for key, values := range e.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("otlp exporter: %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string                 `json:"traceId"`
+	SpanID            string                 `json:"spanId"`
+	ParentSpanID      string                 `json:"parentSpanId,omitempty"`
+	Name              string                 `json:"name"`
+	Kind              SpanKind               `json:"kind"`
+	StartTimeUnixNano string                 `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string                 `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue         `json:"attributes,omitempty"`
+	Status            map[string]interface{} `json:"status,omitempty"`
+}
+
+func otlpTraceRequest(serviceName string, spans []*Span) interface{} {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mtx.Lock()
+		s := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		}
+		if span.ParentSpanID.IsValid() {
+			s.ParentSpanID = span.ParentSpanID.String()
+		}
+		for key, value := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttribute(key, value))
+		}
+		if span.Err != nil {
+			s.Status = map[string]interface{}{"code": 2, "message": span.Err.Error()}
+		}
+		span.mtx.Unlock()
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpKeyValue{otlpAttribute("service.name", serviceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "github.com/razzie/beepboop"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttribute(key string, value interface{}) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch value := value.(type) {
+	case string:
+		kv.Value = map[string]interface{}{"stringValue": value}
+	case bool:
+		kv.Value = map[string]interface{}{"boolValue": value}
+	case int:
+		kv.Value = map[string]interface{}{"intValue": strconv.Itoa(value)}
+	case int64:
+		kv.Value = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		kv.Value = map[string]interface{}{"doubleValue": value}
+	default:
+		kv.Value = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+	return kv
+}
@@ -0,0 +1,187 @@
+package beepboop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// collectorStub is a local OTLP/HTTP collector that records the received spans
+type collectorStub struct {
+	*httptest.Server
+	mtx     sync.Mutex
+	status  int
+	headers []http.Header
+	spans   []map[string]interface{}
+	service string
+}
+
+func newCollectorStub(t *testing.T) *collectorStub {
+	stub := &collectorStub{status: http.StatusOK}
+	stub.Server = httptest.NewServer(http.HandlerFunc(stub.handle))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (stub *collectorStub) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]interface{} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stub.mtx.Lock()
+	defer stub.mtx.Unlock()
+	stub.headers = append(stub.headers, r.Header.Clone())
+	for _, rs := range req.ResourceSpans {
+		for _, attr := range rs.Resource.Attributes {
+			if attr.Key == "service.name" {
+				stub.service, _ = attr.Value["stringValue"].(string)
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			stub.spans = append(stub.spans, ss.Spans...)
+		}
+	}
+	w.WriteHeader(stub.status)
+}
+
+func (stub *collectorStub) span(name string) map[string]interface{} {
+	stub.mtx.Lock()
+	defer stub.mtx.Unlock()
+	for _, span := range stub.spans {
+		if span["name"] == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func spanAttribute(span map[string]interface{}, key string) map[string]interface{} {
+	attrs, _ := span["attributes"].([]interface{})
+	for _, attr := range attrs {
+		if kv, _ := attr.(map[string]interface{}); kv["key"] == key {
+			value, _ := kv["value"].(map[string]interface{})
+			return value
+		}
+	}
+	return nil
+}
+
+func TestOTLPExporter(t *testing.T) {
+	stub := newCollectorStub(t)
+	exporter := NewOTLPExporter(stub.URL + "/")
+	exporter.Header.Set("Authorization", "Bearer token")
+	tracer := NewTracer("test-service", exporter)
+	defer tracer.Close()
+
+	ctx, parent := tracer.StartSpan(context.Background(), "parent", SpanKindServer)
+	_, child := tracer.StartSpan(ctx, "child", SpanKindInternal)
+	child.SetAttribute("count", 42)
+	child.SetAttribute("ok", true)
+	child.SetError(errors.New("failure"))
+	child.End()
+	parent.End()
+	tracer.Flush()
+
+	if stub.service != "test-service" {
+		t.Errorf("service.name = %q", stub.service)
+	}
+	if len(stub.headers) != 1 {
+		t.Fatalf("collector received %d requests, expected 1", len(stub.headers))
+	}
+	if auth := stub.headers[0].Get("Authorization"); auth != "Bearer token" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if ct := stub.headers[0].Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	p, c := stub.span("parent"), stub.span("child")
+	if p == nil || c == nil {
+		t.Fatalf("missing spans: %v", stub.spans)
+	}
+	if p["traceId"] != parent.TraceID.String() || c["traceId"] != parent.TraceID.String() {
+		t.Errorf("trace IDs = %v, %v, expected %s", p["traceId"], c["traceId"], parent.TraceID)
+	}
+	if c["parentSpanId"] != parent.SpanID.String() {
+		t.Errorf("parentSpanId = %v, expected %s", c["parentSpanId"], parent.SpanID)
+	}
+	if _, ok := p["parentSpanId"]; ok {
+		t.Errorf("root span has parentSpanId %v", p["parentSpanId"])
+	}
+	if kind := p["kind"]; kind != float64(SpanKindServer) {
+		t.Errorf("kind = %v", kind)
+	}
+	if v := spanAttribute(c, "count"); v["intValue"] != "42" {
+		t.Errorf("count = %v", v)
+	}
+	if v := spanAttribute(c, "ok"); v["boolValue"] != true {
+		t.Errorf("ok = %v", v)
+	}
+	status, _ := c["status"].(map[string]interface{})
+	if status["code"] != float64(2) || status["message"] != "failure" {
+		t.Errorf("status = %v", status)
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	stub := newCollectorStub(t)
+	stub.status = http.StatusServiceUnavailable
+	exporter := NewOTLPExporter(stub.URL)
+
+	_, span := NewTracer("test-service", nil).StartSpan(context.Background(), "span", SpanKindInternal)
+	err := exporter.ExportSpans(context.Background(), "test-service", []*Span{span})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected 503 error, got %v", err)
+	}
+}
+
+func traceTestMiddleware(pr *PageRequest) *View {
+	return nil
+}
+
+func TestMiddlewareSpans(t *testing.T) {
+	stub := newCollectorStub(t)
+	srv := NewServer()
+	srv.Tracer = NewTracer("test-service", NewOTLPExporter(stub.URL))
+	defer srv.Tracer.Close()
+	srv.AddMiddleware(traceTestMiddleware)
+	srv.AddPages(&Page{
+		Path: "/traced",
+		Handler: func(pr *PageRequest) *View {
+			return pr.Respond("ok")
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/traced", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	srv.Tracer.Flush()
+
+	span := stub.span("github.com/razzie/beepboop.traceTestMiddleware")
+	if span == nil {
+		t.Fatalf("no middleware span in %v", stub.spans)
+	}
+	if span["traceId"] != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("traceId = %v", span["traceId"])
+	}
+}
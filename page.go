@@ -1,49 +1,102 @@
 package beepboop
 
 import (
+	"fmt"
 	"io/fs"
 	"net/http"
 	"os"
-	"path"
 )
 
 // Page ...
 type Page struct {
-	Path            string
-	Title           string
-	ContentTemplate string
-	Stylesheets     []string
-	Scripts         []string
-	Metadata        map[string]string
-	Handler         func(*PageRequest) *View
-	OnlyLogOnError  bool
+	Name                string // used to build URLs of the page in templates (like {{PageURL "name"}})
+	Path                string
+	Title               string
+	ContentTemplate     string
+	ContentTemplateFile string // loaded from TemplateFS if ContentTemplate is empty
+	TemplateFS          fs.FS
+	ReloadTemplate      bool // re-parse the content template when it changes (development mode)
+	Stylesheets         []string
+	Scripts             []string
+	Metadata            map[string]string
+	Handler             func(*PageRequest) *View
+	OnlyLogOnError      bool
+	Cache               *CachePolicy // caches the rendered responses of the page (see PageCache)
+	assetFS             fs.FS
 }
 
 // GetHandler creates a http.Handler that uses the given layout to render the page
 func (page *Page) GetHandler(layout Layout, getctx ContextGetter) (http.Handler, error) {
-	renderer, err := layout.BindTemplate(page.ContentTemplate, page.Stylesheets, page.Scripts, page.Metadata)
+	renderer, err := page.bindTemplate(layout)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("page %s: %v", page.Path, err)
 	}
 	return page.getHandler(getctx, layout, renderer), nil
 }
 
+func (page *Page) bindTemplate(layout Layout) (LayoutRenderer, error) {
+	if len(page.ContentTemplate) > 0 || len(page.ContentTemplateFile) == 0 {
+		return layout.BindTemplate(page.ContentTemplate, page.Stylesheets, page.Scripts, page.Metadata)
+	}
+	if page.TemplateFS == nil {
+		return nil, fmt.Errorf("no TemplateFS to load %s from", page.ContentTemplateFile)
+	}
+
+	bind := func() (LayoutRenderer, error) {
+		contentTemplate, err := fs.ReadFile(page.TemplateFS, page.ContentTemplateFile)
+		if err != nil {
+			return nil, err
+		}
+		return layout.BindTemplate(string(contentTemplate), page.Stylesheets, page.Scripts, page.Metadata)
+	}
+	if page.ReloadTemplate {
+		files := func() ([]string, error) {
+			return []string{page.ContentTemplateFile}, nil
+		}
+		return newReloadingRenderer(page.TemplateFS, files, bind)
+	}
+	return bind()
+}
+
 // GetAPIHandler creates a http.Handler that handles API requests of the page
 func (page *Page) GetAPIHandler(getctx ContextGetter) http.Handler {
 	return page.getHandler(getctx, nil, nil)
 }
 
 func (page *Page) getHandler(getctx ContextGetter, layout Layout, renderer LayoutRenderer) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var handler http.HandlerFunc
+	handler = func(w http.ResponseWriter, r *http.Request) {
 		ctx := getctx(r.Context(), layout)
+		r, span := ctx.startRequestSpan(r, page.Path)
+		defer span.End()
 		pr := newPageRequest(page, r, ctx, renderer)
-		if !page.OnlyLogOnError {
-			pr.logRequestNonblocking()
+		pr.Request = withTheme(withRenderErrorHandler(pr.Request, pr.renderError), ctx.Theme)
+		if ctx.I18n != nil {
+			pr.Request = withLocalizer(pr.Request, pr.Localizer())
+		}
+		pr.Request = withPageRequest(pr)
+		span.SetAttribute("beepboop.request_id", pr.RequestID)
+		// background revalidations aren't made by clients, so only their errors are logged
+		revalidation := isRevalidation(r)
+		if revalidation {
+			span.SetAttribute("beepboop.cache_revalidation", true)
+		}
+		if !page.OnlyLogOnError && !revalidation {
+			pr.logRequest()
 		}
 
 		view := ctx.runMiddlewares(pr)
+		var cacheKey string
+		if view == nil && page.Cache.allows(pr) {
+			cacheKey = page.Cache.key(pr)
+			if page.serveCached(handler, w, pr, cacheKey) {
+				return
+			}
+		}
 		if view == nil && page.Handler != nil {
+			handlerSpan := pr.startSpan("handler")
 			view = page.Handler(pr)
+			handlerSpan.End()
 		}
 		if view == nil {
 			view = pr.Respond(nil)
@@ -51,12 +104,19 @@ func (page *Page) getHandler(getctx ContextGetter, layout Layout, renderer Layou
 
 		defer view.Close()
 		pr.updateSession(view)
-		if pr.IsAPI {
-			view.RenderAPIResponse(w)
+		span.SetAttribute("http.status_code", view.StatusCode)
+		span.SetError(view.Error)
+		renderSpan := pr.startSpan("render")
+		defer renderSpan.End()
+		w, finish := ctx.Compression.wrap(w, r)
+		defer finish()
+		if len(cacheKey) > 0 && !view.stream {
+			page.renderCached(w, pr, view, cacheKey)
 		} else {
-			view.Render(w)
+			pr.renderView(w, view)
 		}
-	})
+	}
+	return handler
 }
 
 func (page *Page) addMetadata(meta map[string]string) {
@@ -69,31 +129,35 @@ func (page *Page) addMetadata(meta map[string]string) {
 }
 
 // StaticAssetPage returns a page that serves static assets from a directory
+// like FSPage, except that its subdirectories are forbidden (even with an index.html)
 func StaticAssetPage(pagePath, assetDir string) *Page {
-	handler := func(pr *PageRequest) *View {
-		uri := path.Clean(pr.RelPath)
-		if fi, _ := os.Stat(path.Join(assetDir, uri)); fi != nil && fi.IsDir() {
+	fsys := os.DirFS(assetDir)
+	page := FSPage(pagePath, fsys)
+	serve := page.Handler
+	page.Handler = func(pr *PageRequest) *View {
+		if fi, _ := fs.Stat(fsys, cleanAssetName(pr.RelPath)); fi != nil && fi.IsDir() {
 			return pr.ErrorView("Forbidden", http.StatusForbidden)
 		}
-		return pr.HandlerView(func(w http.ResponseWriter, r *http.Request) {
-			http.ServeFile(w, r, path.Join(assetDir, uri))
-		})
-	}
-	return &Page{
-		Path:           pagePath,
-		Handler:        handler,
-		OnlyLogOnError: true,
+		return serve(pr)
 	}
+	return page
 }
 
-// FSPage returns a page that serves assets from fs.FS
+// FSPage returns a page that serves assets from fs.FS.
+// Assets are served with ETags, precompressed variants (like app.css.br, app.css.zst or app.css.gz
+// next to app.css, or compressed in memory) and are cached forever by clients
+// if requested by their fingerprinted URL (see AssetURL).
+// Directories are served by their index.html and are forbidden without one (they aren't listed).
 func FSPage(pagePath string, f fs.FS) *Page {
 	handler := func(pr *PageRequest) *View {
-		return pr.HandlerView(http.FileServer(http.FS(f)).ServeHTTP)
+		return pr.HandlerView(func(w http.ResponseWriter, r *http.Request) {
+			pr.Context.assets.serve(w, r, pagePath, f, pr.RelPath)
+		})
 	}
 	return &Page{
 		Path:           pagePath,
 		Handler:        handler,
 		OnlyLogOnError: true,
+		assetFS:        f,
 	}
 }
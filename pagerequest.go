@@ -3,14 +3,12 @@ package beepboop
 import (
 	"context"
 	"fmt"
-	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/mssola/user_agent"
 	"github.com/razzie/babble"
-	"github.com/razzie/reqip"
 )
 
 // PageRequest ...
@@ -18,6 +16,7 @@ type PageRequest struct {
 	Context   *Context
 	Request   *http.Request
 	RequestID string
+	ClientIP  string
 	RelPath   string
 	RelURI    string
 	PagePath  string
@@ -26,6 +25,10 @@ type PageRequest struct {
 	renderer  LayoutRenderer
 	logged    bool
 	session   *Session
+	ipInfo    *IPInfo
+	csrf      string
+	csrfNew   bool
+	noCache   bool // the response contains client specific data (like the CSRF token)
 }
 
 func newPageRequest(page *Page, r *http.Request, ctx *Context, renderer LayoutRenderer) *PageRequest {
@@ -33,6 +36,7 @@ func newPageRequest(page *Page, r *http.Request, ctx *Context, renderer LayoutRe
 		Context:   ctx,
 		Request:   r,
 		RequestID: newRequestID(),
+		ClientIP:  ctx.ClientIPResolver.ClientIP(r),
 		PagePath:  page.Path,
 		Title:     page.Title,
 		IsAPI:     renderer == nil,
@@ -55,57 +59,95 @@ func newRequestID() string {
 }
 
 func (r *PageRequest) logRequest() {
-	ip := reqip.GetClientIP(r.Request)
+	ip := r.ClientIP
 	ua := user_agent.New(r.Request.UserAgent())
 	browser, ver := ua.Browser()
 
-	logmsg := fmt.Sprintf("[%s]: %s %s\n • %s, %s %s %s",
+	entry := &logEntry{
+		ctx:         detachContext(r.Request.Context()),
+		logger:      r.Context.Logger,
+		geoipClient: r.Context.GeoIPClient,
+		db:          r.Context.DB,
+		cache:       r.Context.IPInfoCache,
+		ip:          ip,
+	}
+	entry.head = fmt.Sprintf("[%s]: %s %s\n • %s, %s %s %s",
 		r.RequestID, r.Request.Method, r.Request.RequestURI,
 		ip, ua.OS(), browser, ver)
 
-	var hasLocation bool
-	if r.Context.GeoIPClient != nil {
-		loc, _ := r.Context.GeoIPClient.GetLocation(context.Background(), ip)
-		if loc != nil {
-			hasLocation = true
-			logmsg += ", " + loc.String()
-		}
-	}
-	if !hasLocation {
-		hostnames, _ := net.LookupAddr(ip)
-		logmsg += ", " + strings.Join(hostnames, ", ")
-	}
-
 	session, _ := r.Request.Cookie("session")
 	if session != nil {
-		logmsg += ", session: " + session.Value
+		entry.tail += ", session: " + session.Value
+	}
+	if traceID := r.TraceID(); len(traceID) > 0 {
+		entry.tail += ", trace: " + traceID
+	}
+	if isRevalidation(r.Request) {
+		entry.tail += ", cache revalidation"
 	}
 
-	r.Context.Logger.Print(logmsg)
-	r.logged = true
-}
-
-func (r *PageRequest) logRequestNonblocking() {
 	r.logged = true
-	go r.logRequest()
+	if r.Context.logs != nil {
+		r.Context.logs.enqueue(entry)
+	} else {
+		entry.write("")
+	}
 }
 
 // Log ...
 func (r *PageRequest) Log(a ...interface{}) {
 	if !r.logged {
-		r.logRequestNonblocking()
+		r.logRequest()
 	}
-	prefix := fmt.Sprintf("[%s] ", r.RequestID)
-	r.Context.Logger.Output(2, prefix+fmt.Sprint(a...))
+	r.Context.Logger.Output(2, r.logPrefix()+fmt.Sprint(a...))
 }
 
 // Logf ...
 func (r *PageRequest) Logf(format string, a ...interface{}) {
 	if !r.logged {
-		r.logRequestNonblocking()
+		r.logRequest()
+	}
+	r.Context.Logger.Output(2, r.logPrefix()+fmt.Sprintf(format, a...))
+}
+
+func (r *PageRequest) logPrefix() string {
+	if traceID := r.TraceID(); len(traceID) > 0 {
+		return fmt.Sprintf("[%s trace:%s] ", r.RequestID, traceID)
+	}
+	return fmt.Sprintf("[%s] ", r.RequestID)
+}
+
+// TraceID returns the trace ID of the request or an empty string if tracing is disabled
+func (r *PageRequest) TraceID() string {
+	if span := SpanFromContext(r.Request.Context()); span != nil {
+		return span.TraceID.String()
+	}
+	return ""
+}
+
+// StartSpan starts a child span of the request span
+// (the returned span is nil but safe to use if tracing is disabled)
+func (r *PageRequest) StartSpan(name string) (context.Context, *Span) {
+	return StartSpan(r.Request.Context(), name, SpanKindInternal)
+}
+
+func (r *PageRequest) startSpan(name string) *Span {
+	_, span := r.StartSpan(name)
+	return span
+}
+
+// IPInfo returns the location and hostnames of the client IP (cached per IP)
+func (r *PageRequest) IPInfo() (*IPInfo, error) {
+	if r.ipInfo != nil {
+		return r.ipInfo, nil
 	}
-	prefix := fmt.Sprintf("[%s] ", r.RequestID)
-	r.Context.Logger.Output(2, prefix+fmt.Sprintf(format, a...))
+	ip := r.ClientIP
+	info, err := r.Context.IPInfoCache.Lookup(r.Request.Context(), ip, r.Context.GeoIPClient, r.Context.DB)
+	if err != nil {
+		return nil, err
+	}
+	r.ipInfo = info
+	return info, nil
 }
 
 // Respond returns the default page response View
@@ -123,6 +165,14 @@ func (r *PageRequest) Respond(data interface{}, opts ...ViewOption) *View {
 	return v
 }
 
+func (r *PageRequest) renderView(w http.ResponseWriter, view *View) {
+	if r.IsAPI && !view.stream {
+		view.renderAPIResponse(w, r.Request)
+	} else {
+		view.Render(w)
+	}
+}
+
 // Session returns the current session
 func (r *PageRequest) Session() *Session {
 	if r.session == nil {
@@ -132,7 +182,28 @@ func (r *PageRequest) Session() *Session {
 }
 
 func (r *PageRequest) updateSession(view *View) {
+	view.cookies = append(view.cookies, r.sessionCookies()...)
+}
+
+// sessionCookies returns the cookies of the session and the new CSRF token (if any)
+func (r *PageRequest) sessionCookies() []*http.Cookie {
+	var cookies []*http.Cookie
 	if r.session != nil {
-		view.cookies = append(view.cookies, r.session.toCookies(r.Context.CookieExpiration)...)
+		cookies = append(cookies, r.session.toCookies(r.Context.CookieExpiration)...)
+	}
+	if r.csrfNew {
+		cookies = append(cookies, r.csrfCookie(r.csrf))
 	}
+	return cookies
+}
+
+type pageRequestKey struct{}
+
+func withPageRequest(r *PageRequest) *http.Request {
+	return r.Request.WithContext(context.WithValue(r.Request.Context(), pageRequestKey{}, r))
+}
+
+func getPageRequest(r *http.Request) *PageRequest {
+	pr, _ := r.Context().Value(pageRequestKey{}).(*PageRequest)
+	return pr
 }
@@ -0,0 +1,476 @@
+package beepboop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hopHeaders are the hop-by-hop headers that proxies don't forward (RFC 7230)
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// forwardedHeaders are only forwarded if the request comes from a trusted proxy
+var forwardedHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Prefix",
+	"X-Real-IP",
+}
+
+// ProxyTarget is an upstream server of a ReverseProxy
+type ProxyTarget struct {
+	URL       *url.URL
+	mtx       sync.Mutex
+	unhealthy bool      // failed the last health check
+	downUntil time.Time // a request to the target failed recently
+}
+
+// Available returns whether the target passed the last health check and hasn't failed recently
+func (target *ProxyTarget) Available() bool {
+	target.mtx.Lock()
+	defer target.mtx.Unlock()
+	return !target.unhealthy && time.Now().After(target.downUntil)
+}
+
+func (target *ProxyTarget) fail(timeout time.Duration) {
+	target.mtx.Lock()
+	defer target.mtx.Unlock()
+	target.downUntil = time.Now().Add(timeout)
+}
+
+func (target *ProxyTarget) setHealthy(healthy bool) {
+	target.mtx.Lock()
+	defer target.mtx.Unlock()
+	target.unhealthy = !healthy
+}
+
+// ReverseProxy forwards requests to its targets in round robin order and streams the responses back.
+// Hop-by-hop headers are stripped, X-Forwarded-* headers are added and WebSocket upgrades are passed through.
+type ReverseProxy struct {
+	Targets             []*ProxyTarget
+	Transport           http.RoundTripper // Timeout only applies to the default transport
+	Timeout             time.Duration     // of connecting to a target and receiving the response header
+	Retries             int               // attempts on other targets if a target can't be reached
+	FailTimeout         time.Duration     // targets are skipped for this long after a failed request
+	HealthCheckPath     string            // requested periodically if not empty (2xx and 3xx responses are healthy)
+	HealthCheckInterval time.Duration
+	FlushInterval       time.Duration // of response bodies (-1 flushes after each write)
+	PreserveHost        bool          // send the Host header of the client instead of the target's
+	ModifyResponse      func(*http.Response) error
+	next                uint32
+	transportOnce       sync.Once
+	transport           http.RoundTripper
+	stop                chan struct{}
+	stopOnce            sync.Once
+}
+
+// ProxyOption is used to customize the ReverseProxy created by NewReverseProxy
+type ProxyOption func(proxy *ReverseProxy)
+
+// WithProxyTimeout sets the timeout of connecting to a target and receiving the response header
+func WithProxyTimeout(timeout time.Duration) ProxyOption {
+	return func(proxy *ReverseProxy) {
+		proxy.Timeout = timeout
+	}
+}
+
+// WithRetries sets the number of attempts on other targets if a target can't be reached
+func WithRetries(retries int) ProxyOption {
+	return func(proxy *ReverseProxy) {
+		proxy.Retries = retries
+	}
+}
+
+// WithHealthCheck makes the proxy request a path of the targets periodically
+// and skip the ones that don't respond with 2xx or 3xx
+func WithHealthCheck(path string, interval time.Duration) ProxyOption {
+	return func(proxy *ReverseProxy) {
+		proxy.HealthCheckPath = path
+		proxy.HealthCheckInterval = interval
+	}
+}
+
+// WithPreserveHost makes the proxy send the Host header of the client to the targets
+func WithPreserveHost() ProxyOption {
+	return func(proxy *ReverseProxy) {
+		proxy.PreserveHost = true
+	}
+}
+
+// WithProxyTransport sets the http.RoundTripper used to send requests to the targets
+func WithProxyTransport(transport http.RoundTripper) ProxyOption {
+	return func(proxy *ReverseProxy) {
+		proxy.Transport = transport
+	}
+}
+
+// NewReverseProxy returns a new ReverseProxy that forwards requests to the given target URLs
+// (and starts health checking them if WithHealthCheck is used, until Close is called)
+func NewReverseProxy(targets []string, opts ...ProxyOption) (*ReverseProxy, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("no proxy targets")
+	}
+	proxy := &ReverseProxy{
+		Timeout:             30 * time.Second,
+		Retries:             2,
+		FailTimeout:         10 * time.Second,
+		HealthCheckInterval: 10 * time.Second,
+		stop:                make(chan struct{}),
+	}
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return nil, fmt.Errorf("invalid proxy target: %s", target)
+		}
+		proxy.Targets = append(proxy.Targets, &ProxyTarget{URL: u})
+	}
+	for _, opt := range opts {
+		opt(proxy)
+	}
+	if len(proxy.HealthCheckPath) > 0 && proxy.HealthCheckInterval > 0 {
+		go proxy.healthCheck()
+	}
+	return proxy, nil
+}
+
+// Close stops the health checks
+func (proxy *ReverseProxy) Close() error {
+	proxy.stopOnce.Do(func() {
+		close(proxy.stop)
+	})
+	return nil
+}
+
+// ServeHTTP forwards the request to a target
+func (proxy *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	proxy.serve(w, r, "")
+}
+
+type proxyPrefixKey struct{}
+
+// serve forwards the request without the path prefix (which is sent in X-Forwarded-Prefix)
+func (proxy *ReverseProxy) serve(w http.ResponseWriter, r *http.Request, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if len(prefix) > 0 {
+		u := *r.URL
+		u.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, prefix), "/")
+		u.RawPath = ""
+		r = r.WithContext(context.WithValue(r.Context(), proxyPrefixKey{}, prefix))
+		r.URL = &u
+	}
+	rp := &httputil.ReverseProxy{
+		Director:       proxy.director,
+		Transport:      roundTripperFunc(proxy.roundTrip),
+		FlushInterval:  proxy.FlushInterval,
+		ModifyResponse: proxy.ModifyResponse,
+		ErrorHandler:   proxy.handleError,
+	}
+	rp.ServeHTTP(w, r)
+}
+
+// director sets the forwarding headers of an outgoing request (the target is picked by roundTrip)
+func (proxy *ReverseProxy) director(req *http.Request) {
+	if !trustsForwardedHeaders(req) {
+		for _, header := range forwardedHeaders {
+			req.Header.Del(header)
+		}
+	}
+	if len(req.Header.Get("X-Forwarded-Host")) == 0 {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	if len(req.Header.Get("X-Forwarded-Proto")) == 0 {
+		if req.TLS != nil {
+			req.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			req.Header.Set("X-Forwarded-Proto", "http")
+		}
+	}
+	if prefix, ok := req.Context().Value(proxyPrefixKey{}).(string); ok {
+		req.Header.Set("X-Forwarded-Prefix", req.Header.Get("X-Forwarded-Prefix")+prefix)
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// don't let the transport send its default User-Agent
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+// trustsForwardedHeaders returns whether the request comes from a trusted proxy
+// (according to the ClientIPResolver of the page, or the default one)
+func trustsForwardedHeaders(r *http.Request) bool {
+	res := defaultClientIPResolver
+	if pr := getPageRequest(r); pr != nil {
+		res = pr.Context.ClientIPResolver
+	}
+	return res.trustsRemoteAddr(r.RemoteAddr)
+}
+
+// roundTrip sends the request to the next available target and retries on other targets
+// if the target can't be reached (or on any error if the request is idempotent).
+// Requests with a body are only retried if GetBody can replay it.
+func (proxy *ReverseProxy) roundTrip(req *http.Request) (*http.Response, error) {
+	var err error
+	for attempt := 0; attempt <= proxy.Retries; attempt++ {
+		target := proxy.pick()
+		outreq := req.Clone(req.Context())
+		if attempt > 0 && hasBody(req) {
+			// the transport closed the body of the previous attempt
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				break
+			}
+			outreq.Body = body
+		}
+		outreq.URL.Scheme = target.URL.Scheme
+		outreq.URL.Host = target.URL.Host
+		outreq.URL.Path, outreq.URL.RawPath = joinURLPath(target.URL, req.URL)
+		if len(target.URL.RawQuery) > 0 && len(req.URL.RawQuery) > 0 {
+			outreq.URL.RawQuery = target.URL.RawQuery + "&" + req.URL.RawQuery
+		} else {
+			outreq.URL.RawQuery = target.URL.RawQuery + req.URL.RawQuery
+		}
+		if !proxy.PreserveHost {
+			outreq.Host = ""
+		}
+
+		var resp *http.Response
+		resp, err = proxy.getTransport().RoundTrip(outreq)
+		if err == nil {
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			// the client is gone (or timed out), which isn't the target's fault
+			break
+		}
+		target.fail(proxy.FailTimeout)
+		if !canRetry(req, err) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// pick returns the next available target (or the next target if none are available)
+func (proxy *ReverseProxy) pick() *ProxyTarget {
+	n := len(proxy.Targets)
+	start := int(atomic.AddUint32(&proxy.next, 1) - 1)
+	for i := 0; i < n; i++ {
+		if target := proxy.Targets[(start+i)%n]; target.Available() {
+			return target
+		}
+	}
+	return proxy.Targets[start%n]
+}
+
+func (proxy *ReverseProxy) getTransport() http.RoundTripper {
+	if proxy.Transport != nil {
+		return proxy.Transport
+	}
+	proxy.transportOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{
+			Timeout:   proxy.Timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.ResponseHeaderTimeout = proxy.Timeout
+		proxy.transport = transport
+	})
+	return proxy.transport
+}
+
+func (proxy *ReverseProxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		// the client is gone
+		return
+	}
+	status := http.StatusBadGateway
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		status = http.StatusGatewayTimeout
+	}
+	pr := getPageRequest(r)
+	if pr == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	pr.Logf("proxy error: %v", err)
+	pr.renderView(w, pr.ErrorView(http.StatusText(status), status))
+}
+
+// healthCheck checks the targets periodically until the proxy is closed
+func (proxy *ReverseProxy) healthCheck() {
+	ticker := time.NewTicker(proxy.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, target := range proxy.Targets {
+			wg.Add(1)
+			go func(target *ProxyTarget) {
+				defer wg.Done()
+				target.setHealthy(proxy.check(target))
+			}(target)
+		}
+		wg.Wait()
+
+		select {
+		case <-proxy.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (proxy *ReverseProxy) check(target *ProxyTarget) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), proxy.HealthCheckInterval)
+	defer cancel()
+	u := *target.URL
+	u.Path, u.RawPath = singleJoiningSlash(u.Path, proxy.HealthCheckPath), ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := proxy.getTransport().RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// canRetry returns whether a failed request can be sent again
+func canRetry(req *http.Request, err error) bool {
+	if hasBody(req) && req.GetBody == nil {
+		// the transport closes the body even if the request wasn't sent
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		// the request wasn't sent
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// joinURLPath joins the paths of the target and the request (keeping their escaping)
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if len(a.RawPath) == 0 && len(b.RawPath) == 0 {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
+
+// copyHeader adds the end-to-end header fields of src to dst
+func copyHeader(dst, src http.Header) {
+	hop := make(map[string]bool)
+	for _, header := range hopHeaders {
+		hop[header] = true
+	}
+	for _, value := range src.Values("Connection") {
+		for _, field := range strings.Split(value, ",") {
+			hop[http.CanonicalHeaderKey(strings.TrimSpace(field))] = true
+		}
+	}
+	for key, values := range src {
+		if hop[key] {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// ProxyView returns a View that forwards the request to the targets of a ReverseProxy
+func ProxyView(r *http.Request, proxy *ReverseProxy, opts ...ViewOption) *View {
+	return newProxyView(r, proxy, "", opts...)
+}
+
+// ProxyView returns a View that forwards the request to the targets of a ReverseProxy
+// (the path of the page is stripped and sent in X-Forwarded-Prefix)
+func (r *PageRequest) ProxyView(proxy *ReverseProxy, opts ...ViewOption) *View {
+	prefix := strings.TrimSuffix(r.Request.URL.Path, r.RelPath)
+	return newProxyView(r.Request, proxy, prefix, opts...)
+}
+
+func newProxyView(r *http.Request, proxy *ReverseProxy, prefix string, opts ...ViewOption) *View {
+	v := &View{
+		StatusCode: http.StatusOK,
+		noCache:    true,
+		stream:     true,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	v.renderer = func(w http.ResponseWriter) {
+		proxy.serve(w, r, prefix)
+	}
+	return v
+}
+
+// ProxyPage returns a page that forwards its requests to the targets of a ReverseProxy
+func ProxyPage(pagePath string, proxy *ReverseProxy) *Page {
+	return &Page{
+		Path: pagePath,
+		Handler: func(pr *PageRequest) *View {
+			return pr.ProxyView(proxy)
+		},
+	}
+}
@@ -0,0 +1,104 @@
+package beepboop
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closedAddr returns the address of a TCP port that refuses connections
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func newEchoTarget(t *testing.T, bodies *[]string) *httptest.Server {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*bodies = append(*bodies, string(body))
+		w.Write(body)
+	}))
+	t.Cleanup(target.Close)
+	return target
+}
+
+func TestReverseProxyDoesntRetryUnreplayableBody(t *testing.T) {
+	var bodies []string
+	target := newEchoTarget(t, &bodies)
+	proxy, err := NewReverseProxy([]string{"http://" + closedAddr(t), target.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// requests received by a server can't replay their body
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("payload")))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d", w.Code)
+	}
+	if len(bodies) > 0 {
+		t.Errorf("retried with bodies %q", bodies)
+	}
+}
+
+func TestReverseProxyRetriesReplayableBody(t *testing.T) {
+	var bodies []string
+	target := newEchoTarget(t, &bodies)
+	proxy, err := NewReverseProxy([]string{"http://" + closedAddr(t), target.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Body = io.NopCloser(bytes.NewReader([]byte("payload")))
+	r.ContentLength = int64(len("payload"))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("payload")), nil
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "payload" {
+		t.Errorf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if len(bodies) != 1 || bodies[0] != "payload" {
+		t.Errorf("target received %q", bodies)
+	}
+	if proxy.Targets[0].Available() {
+		t.Error("the unreachable target is still available")
+	}
+}
+
+func TestReverseProxyCancelDoesntFailTarget(t *testing.T) {
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer target.Close()
+	defer close(release)
+	proxy, err := NewReverseProxy([]string{target.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	proxy.ServeHTTP(httptest.NewRecorder(), r)
+	if !proxy.Targets[0].Available() {
+		t.Error("the target is penalised for a cancelled request")
+	}
+}
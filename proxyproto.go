@@ -0,0 +1,150 @@
+package beepboop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var proxyProtoV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener is a net.Listener that accepts PROXY protocol (v1 and v2)
+// headers from trusted proxies and uses the source address of the header
+// as the remote address of the connection
+type ProxyProtocolListener struct {
+	net.Listener
+	TrustedProxies []*net.IPNet
+	HeaderTimeout  time.Duration
+}
+
+// NewProxyProtocolListener wraps a listener to accept PROXY protocol headers from the given proxies
+func NewProxyProtocolListener(l net.Listener, trustedProxies []*net.IPNet) *ProxyProtocolListener {
+	return &ProxyProtocolListener{
+		Listener:       l,
+		TrustedProxies: trustedProxies,
+		HeaderTimeout:  time.Second * 5,
+	}
+}
+
+// Accept waits for and returns the next connection
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	res := ClientIPResolver{TrustedProxies: l.TrustedProxies}
+	if ip := parseIP(conn.RemoteAddr().String()); ip == nil || !res.isTrusted(ip) {
+		return conn, nil
+	}
+	return &proxyProtoConn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: l.HeaderTimeout,
+	}, nil
+}
+
+type proxyProtoConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	timeout    time.Duration
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) readHeader() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	if sig, _ := c.reader.Peek(len(proxyProtoV2Signature)); bytes.Equal(sig, proxyProtoV2Signature) {
+		c.remoteAddr, c.err = readProxyProtoV2(c.reader)
+		return
+	}
+	if prefix, _ := c.reader.Peek(6); string(prefix) == "PROXY " {
+		c.remoteAddr, c.err = readProxyProtoV1(c.reader)
+	}
+}
+
+func readProxyProtoV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 source address")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyProtoV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version")
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if hdr[12]&0x0f == 0 { // LOCAL command (health checks etc.)
+		return nil, nil
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("invalid PROXY protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("invalid PROXY protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package beepboop
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SetQuery returns the URL of the request with the given query parameters
+// (key, value, key, value...) set or removed if their value is empty
+func SetQuery(r *http.Request, pairs ...string) string {
+	query, path := requestQuery(r)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if len(pairs[i+1]) == 0 {
+			query.Del(pairs[i])
+		} else {
+			query.Set(pairs[i], pairs[i+1])
+		}
+	}
+	return joinQuery(path, query)
+}
+
+// RemoveQuery returns the URL of the request without the given query parameters
+func RemoveQuery(r *http.Request, keys ...string) string {
+	query, path := requestQuery(r)
+	for _, key := range keys {
+		query.Del(key)
+	}
+	return joinQuery(path, query)
+}
+
+// ToggleSort returns the URL of the request sorted by the given key,
+// or in descending order ("-key") if it's already sorted by it
+func ToggleSort(r *http.Request, key string) string {
+	query, _ := requestQuery(r)
+	if query.Get("sort") == key {
+		key = "-" + key
+	}
+	return SetQuery(r, "sort", key, "order", "", "page", "")
+}
+
+func requestQuery(r *http.Request) (url.Values, string) {
+	if r == nil {
+		return make(url.Values), ""
+	}
+	return r.URL.Query(), r.URL.EscapedPath()
+}
+
+func joinQuery(path string, query url.Values) string {
+	if len(query) == 0 {
+		if len(path) == 0 {
+			return "?"
+		}
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// pagination renders links to the pages around the current one (1-based)
+func pagination(r *http.Request, l *Localizer, current, total int) template.HTML {
+	if total <= 1 {
+		return ""
+	}
+	link := func(page int, text string) string {
+		href := SetQuery(r, "page", strconv.Itoa(page))
+		return fmt.Sprintf(`<a href="%s">%s</a>`, template.HTMLEscapeString(href), template.HTMLEscapeString(text))
+	}
+
+	var html strings.Builder
+	html.WriteString(`<nav class="pagination">`)
+	if current > 1 {
+		html.WriteString(link(current-1, "« "+l.T("Previous")))
+	}
+	const window = 2
+	for page := 1; page <= total; page++ {
+		switch {
+		case page == current:
+			fmt.Fprintf(&html, `<strong>%d</strong>`, page)
+		case page == 1 || page == total || (page >= current-window && page <= current+window):
+			html.WriteString(link(page, strconv.Itoa(page)))
+		case page == current-window-1 || page == current+window+1:
+			html.WriteString(`<span>…</span>`)
+		}
+	}
+	if current < total {
+		html.WriteString(link(current+1, l.T("Next")+" »"))
+	}
+	html.WriteString(`</nav>`)
+	return template.HTML(html.String())
+}
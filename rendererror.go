@@ -0,0 +1,159 @@
+package beepboop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// TemplateError is returned when parsing or executing a template fails
+type TemplateError struct {
+	Template string
+	Line     int
+	Err      error
+	Data     interface{}
+}
+
+var templateErrorRegexp = regexp.MustCompile(`template: ([^:]+):(\d+)`)
+
+func newTemplateError(err error, data interface{}) *TemplateError {
+	var tmplErr *TemplateError
+	if errors.As(err, &tmplErr) {
+		return tmplErr
+	}
+	tmplErr = &TemplateError{
+		Err:  err,
+		Data: data,
+	}
+	if m := templateErrorRegexp.FindStringSubmatch(err.Error()); m != nil {
+		tmplErr.Template = m[1]
+		tmplErr.Line, _ = strconv.Atoi(m[2])
+	}
+	return tmplErr
+}
+
+func (err *TemplateError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *TemplateError) Unwrap() error {
+	return err.Err
+}
+
+// renderErrorHandler handles the template errors of a request
+type renderErrorHandler func(w http.ResponseWriter, r *http.Request, err *TemplateError)
+
+type renderErrorKey struct{}
+
+func withRenderErrorHandler(r *http.Request, handler renderErrorHandler) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), renderErrorKey{}, handler))
+}
+
+// handleRenderError passes a template error to the handler of the request
+// or responds with a plain 500 if there is none
+func handleRenderError(w http.ResponseWriter, r *http.Request, err error, data interface{}) {
+	tmplErr := newTemplateError(err, data)
+	if handler, ok := r.Context().Value(renderErrorKey{}).(renderErrorHandler); ok {
+		handler(w, r, tmplErr)
+		return
+	}
+	plainRenderError(w, r, tmplErr)
+}
+
+// plainRenderError logs the error to the logger of the page request (if any)
+// and responds with a plain 500
+func plainRenderError(w http.ResponseWriter, r *http.Request, err *TemplateError) {
+	if pr := getPageRequest(r); pr != nil {
+		pr.Log("template error: ", err)
+	} else {
+		log.Print("template error: ", err)
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+func (r *PageRequest) renderError(w http.ResponseWriter, req *http.Request, err *TemplateError) {
+	r.Log("template error: ", err)
+	span := SpanFromContext(r.Request.Context())
+	span.SetError(err)
+	span.SetAttribute("http.status_code", http.StatusInternalServerError)
+
+	if r.Context.DevMode {
+		renderDevErrorPage(w, err)
+		return
+	}
+	// the error page might use the same broken layout, so don't recurse
+	req = withRenderErrorHandler(req, plainRenderError)
+	renderer := r.Context.ErrorRenderer
+	if renderer == nil {
+		renderer = defaultErrorRenderer
+	}
+	renderer(w, req, "Internal Server Error", http.StatusInternalServerError)
+}
+
+var devErrorT = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<title>Template error</title>
+		<style>
+			body { font-family: sans-serif; margin: 2rem; }
+			pre { background-color: #F0F0F0; padding: 1rem; border-radius: 5px; overflow: auto; }
+			h1 { color: rgb(220, 53, 69); }
+		</style>
+	</head>
+	<body>
+		<h1>Template error</h1>
+		{{if .Template}}<p>in <strong>{{.Template}}</strong>{{if .Line}} at line <strong>{{.Line}}</strong>{{end}}</p>{{end}}
+		<pre>{{.Error}}</pre>
+		<h2>Data</h2>
+		<pre>{{.Data}}</pre>
+	</body>
+</html>
+`))
+
+func renderDevErrorPage(w http.ResponseWriter, err *TemplateError) {
+	data, jsonErr := json.MarshalIndent(err.Data, "", "  ")
+	if jsonErr != nil {
+		data = []byte(fmt.Sprintf("%#v", err.Data))
+	}
+	view := struct {
+		Template string
+		Line     int
+		Error    string
+		Data     string
+	}{
+		Template: err.Template,
+		Line:     err.Line,
+		Error:    err.Error(),
+		Data:     string(data),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	devErrorT.Execute(w, &view)
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	// don't keep huge buffers around
+	if buf.Cap() > 1<<20 {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
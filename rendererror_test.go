@@ -0,0 +1,30 @@
+package beepboop
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderErrorWithoutErrorRenderer(t *testing.T) {
+	var logs bytes.Buffer
+	srv := NewServer()
+	srv.Logger = log.New(&logs, "", 0)
+	srv.ErrorRenderer = nil
+	srv.AddPages(&Page{
+		Path:            "/broken",
+		ContentTemplate: `{{template "missing" .}}`,
+	})
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/broken", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d", w.Code)
+	}
+	if !strings.Contains(logs.String(), "template error") {
+		t.Errorf("template error is not in the request log: %q", logs.String())
+	}
+}
@@ -0,0 +1,184 @@
+package beepboop
+
+import (
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// renderState is the request a pooled template is currently rendering.
+// Template funcs that depend on the request (like T or CSRFField) read it.
+type renderState struct {
+	w         http.ResponseWriter
+	r         *http.Request
+	pr        *PageRequest
+	localizer *Localizer
+}
+
+func (state *renderState) request() *http.Request {
+	if state == nil {
+		return nil
+	}
+	return state.r
+}
+
+func (state *renderState) pageRequest() *PageRequest {
+	if state == nil {
+		return nil
+	}
+	return state.pr
+}
+
+func (state *renderState) getLocalizer() *Localizer {
+	if state == nil {
+		return nil
+	}
+	return state.localizer
+}
+
+// funcMap returns the request dependent template funcs
+// (a nil state returns their defaults used when parsing templates)
+func (state *renderState) funcMap() template.FuncMap {
+	return template.FuncMap{
+		"T": func(msgid string, args ...interface{}) string {
+			return state.getLocalizer().T(msgid, args...)
+		},
+		"N": func(msgid, msgidPlural string, n int, args ...interface{}) string {
+			return state.getLocalizer().N(msgid, msgidPlural, n, args...)
+		},
+		"TC": func(context, msgid string, args ...interface{}) string {
+			return state.getLocalizer().TC(context, msgid, args...)
+		},
+		"NC": func(context, msgid, msgidPlural string, n int, args ...interface{}) string {
+			return state.getLocalizer().NC(context, msgid, msgidPlural, n, args...)
+		},
+		"Locale": func() string {
+			return state.getLocalizer().Locale()
+		},
+		"TimeElapsed": func(then int64) string {
+			return state.getLocalizer().TimeElapsed(time.Now(), time.Unix(then, 0))
+		},
+		"ByteCountSI": func(b int64) string {
+			return state.getLocalizer().ByteCountSI(b)
+		},
+		"ByteCountIEC": func(b int64) string {
+			return state.getLocalizer().ByteCountIEC(b)
+		},
+		"FormatNumber": func(n interface{}) string {
+			return state.getLocalizer().FormatNumber(n)
+		},
+		"FormatDate": func(t interface{}) (string, error) {
+			tt, err := toTime(t)
+			return state.getLocalizer().FormatDate(tt), err
+		},
+		"TimeTag": func(t interface{}) (template.HTML, error) {
+			return timeTag(state.getLocalizer(), t, true)
+		},
+		"DateTimeTag": func(t interface{}) (template.HTML, error) {
+			return timeTag(state.getLocalizer(), t, false)
+		},
+		"PageURL": func(name string, args ...interface{}) (string, error) {
+			pr := state.pageRequest()
+			if pr == nil {
+				return "", errNoRequest
+			}
+			return pr.Context.PageURL(name, args...)
+		},
+		"AssetURL": func(path string) string {
+			if pr := state.pageRequest(); pr != nil {
+				return pr.Context.AssetURL(path)
+			}
+			return path
+		},
+		"CSRFToken": func() string {
+			return state.csrfToken()
+		},
+		"CSRFField": func() template.HTML {
+			return csrfField(state.csrfToken())
+		},
+		"Query": func(key string) string {
+			if r := state.request(); r != nil {
+				return r.URL.Query().Get(key)
+			}
+			return ""
+		},
+		"SetQuery": func(pairs ...string) string {
+			return SetQuery(state.request(), pairs...)
+		},
+		"RemoveQuery": func(keys ...string) string {
+			return RemoveQuery(state.request(), keys...)
+		},
+		"ToggleSort": func(key string) string {
+			return ToggleSort(state.request(), key)
+		},
+		"Pagination": func(current, total int) template.HTML {
+			return pagination(state.request(), state.getLocalizer(), current, total)
+		},
+	}
+}
+
+func (state *renderState) csrfToken() string {
+	pr := state.pageRequest()
+	if pr == nil {
+		return ""
+	}
+	token, isNew := pr.csrfToken()
+	if isNew {
+		// the page is rendered into a buffer, so the header isn't written yet
+		http.SetCookie(state.w, pr.csrfCookie(token))
+	}
+	return token
+}
+
+// templatePool contains clones of a never executed template, each bound to its own render state
+type templatePool struct {
+	master *template.Template
+	mtx    sync.Mutex
+	free   []*pooledTemplate
+}
+
+type pooledTemplate struct {
+	*template.Template
+	state *renderState
+}
+
+func newTemplatePool(master *template.Template) *templatePool {
+	return &templatePool{master: master}
+}
+
+func (pool *templatePool) get(w http.ResponseWriter, r *http.Request) (*pooledTemplate, error) {
+	pool.mtx.Lock()
+	var t *pooledTemplate
+	if n := len(pool.free); n > 0 {
+		t = pool.free[n-1]
+		pool.free = pool.free[:n-1]
+	}
+	pool.mtx.Unlock()
+
+	if t == nil {
+		clone, err := pool.master.Clone()
+		if err != nil {
+			return nil, err
+		}
+		state := &renderState{}
+		t = &pooledTemplate{
+			Template: clone.Funcs(state.funcMap()),
+			state:    state,
+		}
+	}
+	*t.state = renderState{
+		w:         w,
+		r:         r,
+		pr:        getPageRequest(r),
+		localizer: getLocalizer(r),
+	}
+	return t, nil
+}
+
+func (pool *templatePool) put(t *pooledTemplate) {
+	*t.state = renderState{}
+	pool.mtx.Lock()
+	pool.free = append(pool.free, t)
+	pool.mtx.Unlock()
+}
@@ -0,0 +1,56 @@
+package beepboop
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowData keeps a template busy in the middle of rendering
+type slowData struct{}
+
+func (slowData) Wait() string {
+	time.Sleep(time.Millisecond)
+	return ""
+}
+
+func TestTemplateFuncsConcurrentRenders(t *testing.T) {
+	srv := NewServer()
+	srv.Logger = log.New(io.Discard, "", 0)
+	srv.I18n = NewI18n("en")
+	srv.I18n.Catalog("hu").Set("Hello", "Szia")
+	srv.AddPages(&Page{
+		Path:            "/render",
+		ContentTemplate: `<p>{{T "Hello"}} {{Query "n"}}{{.Wait}} {{Locale}} {{Query "n"}}</p>`,
+		Handler: func(pr *PageRequest) *View {
+			return pr.Respond(slowData{})
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lang, hello := "en", "Hello"
+			if i%2 == 1 {
+				lang, hello = "hu", "Szia"
+			}
+			r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/render?n=%d", i), nil)
+			r.Header.Set("Accept-Language", lang)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			expected := fmt.Sprintf("<p>%s %d %s %d</p>", hello, i, lang, i)
+			if body := w.Body.String(); !strings.Contains(body, expected) {
+				t.Errorf("request %d rendered with another request's funcs: %s", i, body)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
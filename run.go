@@ -0,0 +1,186 @@
+package beepboop
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Run starts serving on srv.Addr and blocks until ctx is done or the process
+// receives SIGINT/SIGTERM, then drains in-flight requests and closes the server
+func (srv *Server) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	defer srv.Close()
+
+	l, err := srv.listen()
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := srv.getTLSConfig()
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	shutdown := make(chan struct{})
+	httpSrv := &http.Server{
+		Handler:           srv,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       srv.ReadTimeout,
+		ReadHeaderTimeout: srv.ReadHeaderTimeout,
+		WriteTimeout:      srv.WriteTimeout,
+		IdleTimeout:       srv.IdleTimeout,
+		ErrorLog:          srv.Logger,
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), shutdownKey{}, shutdown)
+		},
+	}
+	// long-lived responses (like SSE streams) end on shutdown instead of blocking it
+	httpSrv.RegisterOnShutdown(func() { close(shutdown) })
+
+	errc := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			errc <- httpSrv.ServeTLS(l, "", "")
+		} else {
+			errc <- httpSrv.Serve(l)
+		}
+	}()
+	srv.Logger.Print("listening on ", srv.Addr)
+
+	var challengeSrv *http.Server
+	if srv.acmeManager != nil {
+		challengeSrv = srv.getACMEChallengeServer()
+		go func() {
+			// HTTPS keeps working without the challenge server (with TLS-ALPN-01 challenges)
+			if err := challengeSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				srv.Logger.Print("ACME challenge server: ", err)
+			}
+		}()
+	}
+
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+
+	srv.Logger.Print("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), srv.ShutdownTimeout)
+	defer cancel()
+	if challengeSrv != nil {
+		challengeSrv.Shutdown(shutdownCtx)
+	}
+	if shutdownErr := httpSrv.Shutdown(shutdownCtx); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
+
+type shutdownKey struct{}
+
+// getShutdownChan returns a channel that is closed when Run shuts down the server
+// (nil if the request isn't served by Run)
+func getShutdownChan(r *http.Request) <-chan struct{} {
+	ch, _ := r.Context().Value(shutdownKey{}).(chan struct{})
+	return ch
+}
+
+func (srv *Server) listen() (net.Listener, error) {
+	var l net.Listener
+	var err error
+	if strings.HasPrefix(srv.Addr, "unix:") {
+		path := strings.TrimPrefix(srv.Addr, "unix:")
+		if fi, statErr := os.Stat(path); statErr == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err = net.Listen("unix", path)
+	} else {
+		l, err = net.Listen("tcp", srv.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if srv.ProxyProtocol {
+		var trusted []*net.IPNet
+		if srv.ClientIPResolver != nil {
+			trusted = srv.ClientIPResolver.TrustedProxies
+		}
+		l = NewProxyProtocolListener(l, trusted)
+	}
+	return l, nil
+}
+
+func (srv *Server) getTLSConfig() (*tls.Config, error) {
+	if srv.acmeManager != nil {
+		return srv.getACMETLSConfig()
+	}
+	if len(srv.TLSCertFile) == 0 {
+		return nil, nil
+	}
+	reloader, err := newCertReloader(srv.TLSCertFile, srv.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// certReloader reloads a certificate and its key when the files change
+type certReloader struct {
+	certFile  string
+	keyFile   string
+	mtx       sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	fi, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = fi.ModTime()
+	return nil
+}
+
+// GetCertificate returns the current certificate and checks for changes at most every 10 seconds
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if time.Since(r.lastCheck) > time.Second*10 {
+		r.lastCheck = time.Now()
+		if fi, err := os.Stat(r.certFile); err == nil && !fi.ModTime().Equal(r.modTime) {
+			// keep serving the old certificate if the new one is not valid (yet)
+			r.reload()
+		}
+	}
+	return r.cert, nil
+}
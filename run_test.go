@@ -0,0 +1,116 @@
+package beepboop
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// closingGeoIPClient records when the server closes it
+type closingGeoIPClient struct {
+	testGeoIPClient
+	onClose func()
+}
+
+func (c *closingGeoIPClient) Close() error {
+	c.onClose()
+	return nil
+}
+
+func TestRunGracefulShutdown(t *testing.T) {
+	var mtx sync.Mutex
+	var events []string
+	event := func(e string) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		events = append(events, e)
+	}
+
+	sock := filepath.Join(t.TempDir(), "run.sock")
+	geoipClient := &closingGeoIPClient{onClose: func() { event("closed") }}
+	srv := NewServer(WithAddr("unix:"+sock), WithGeoIPClient(geoipClient))
+	srv.Logger = log.New(io.Discard, "", 0)
+	srv.ShutdownTimeout = 10 * time.Second
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv.AddPages(&Page{
+		Path:            "/slow",
+		ContentTemplate: "done",
+		Handler: func(pr *PageRequest) *View {
+			close(started)
+			<-release
+			event("request done")
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() { runErr <- srv.Run(ctx) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			for i := 0; ; i++ {
+				conn, err := d.DialContext(ctx, "unix", sock)
+				if err == nil || i == 100 {
+					return conn, err
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		},
+	}}
+	respc := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Get("http://beepboop/slow")
+		if err != nil {
+			t.Error(err)
+		}
+		respc <- resp
+	}()
+	<-started
+	cancel()
+
+	// the listener is closed first while the in-flight request keeps running
+	for i := 0; ; i++ {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if i == 100 {
+			t.Fatal("server still accepts connections after shutdown started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-runErr:
+		t.Fatalf("Run returned before the in-flight request finished: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if resp := <-respc; resp != nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("in-flight request got status %d", resp.StatusCode)
+		}
+	}
+	if err := <-runErr; err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+
+	// resources are closed only after the requests are drained
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(events) != 2 || events[0] != "request done" || events[1] != "closed" {
+		t.Errorf("shutdown events: %q", events)
+	}
+}
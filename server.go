@@ -3,6 +3,8 @@ package beepboop
 import (
 	"context"
 	"encoding/base64"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
@@ -11,6 +13,7 @@ import (
 
 	geoclient "github.com/razzie/geoip-server/client"
 	"github.com/razzie/geoip-server/geoip"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 // Server ...
@@ -23,13 +26,72 @@ type Server struct {
 	DB               *DB
 	Logger           *log.Logger
 	GeoIPClient      geoip.Client
+	Tracer           *Tracer
+	LogPipeline      *LogPipeline
+	IPInfoCache      *IPInfoCache
+	ClientIPResolver *ClientIPResolver
 	Limiters         map[string]*RateLimiter
-	Middlewares      []Middleware
+	Middlewares      []Middleware // use AddMiddleware(s) so that their span names are only resolved once
 	CookieExpiration time.Duration
+	ErrorRenderer    ErrorRenderer // renders the 500 page of template errors
+	Theme            *Theme        // colors and branding of the default layout
+	I18n             *I18n         // message catalogs (i18n is disabled if nil, see SetI18n)
+	Compression      *Compression  // compression of responses (disabled if nil)
+	PageCache        *PageCache    // rendered responses of pages with a CachePolicy (disabled if nil)
+	TemplateFS       fs.FS         // default fs.FS of page templates
+	DevMode          bool          // reload templates when they change and show detailed template errors
+	pages            map[string]*Page
+	assets           *assetRegistry
+	middlewareNames  []string
+	colorSchemePage  bool
+	localePage       bool
+	caches           []LocalCache
+	hubs             []*Hub
+	stopListening    []func()
+
+	// used by Run
+	Addr              string // TCP address like ":8080" or unix socket like "unix:/run/app.sock"
+	TLSCertFile       string
+	TLSKeyFile        string
+	ProxyProtocol     bool // accept PROXY protocol headers from trusted proxies
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	acmeManager       *autocert.Manager
+	acmeHTTPAddr      string
+	acmeCacheDir      string
+}
+
+// ServerOption is used to customize the Server created by NewServer
+type ServerOption func(srv *Server)
+
+// WithGeoIPClient sets the GeoIP client of the server (like an MMDBClient)
+func WithGeoIPClient(client geoip.Client) ServerOption {
+	return func(srv *Server) {
+		srv.GeoIPClient = client
+	}
+}
+
+// WithAddr sets the address the server listens on when calling Run
+func WithAddr(addr string) ServerOption {
+	return func(srv *Server) {
+		srv.Addr = addr
+	}
+}
+
+// WithTLS sets the certificate and key files the server uses when calling Run
+func WithTLS(certFile, keyFile string) ServerOption {
+	return func(srv *Server) {
+		srv.TLSCertFile = certFile
+		srv.TLSKeyFile = keyFile
+	}
 }
 
 // NewServer creates a new Server
-func NewServer() *Server {
+func NewServer(opts ...ServerOption) *Server {
+	theme := DefaultTheme
 	srv := &Server{
 		Layout:           DefaultLayout,
 		FaviconPNG:       favicon,
@@ -37,8 +99,25 @@ func NewServer() *Server {
 		Metadata:         map[string]string{"generator": "https://github.com/razzie/beepboop"},
 		Logger:           log.New(os.Stdout, "", log.LstdFlags),
 		GeoIPClient:      geoclient.DefaultClient,
+		LogPipeline:      NewLogPipeline(4, 1024),
+		IPInfoCache:      NewIPInfoCache(4096, time.Hour),
+		ClientIPResolver: defaultClientIPResolver,
 		Limiters:         make(map[string]*RateLimiter),
 		CookieExpiration: time.Hour * 24 * 7,
+		ErrorRenderer:    defaultErrorRenderer,
+		Theme:            &theme,
+		Compression:      NewCompression(),
+		PageCache:        NewPageCache(128),
+
+		Addr:              ":8080",
+		ReadHeaderTimeout: time.Second * 10,
+		IdleTimeout:       time.Minute * 2,
+		ShutdownTimeout:   time.Second * 30,
+		pages:             make(map[string]*Page),
+		assets:            newAssetRegistry(),
+	}
+	for _, opt := range opts {
+		opt(srv)
 	}
 	srv.mux.HandleFunc("/favicon.png", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "image/png")
@@ -46,9 +125,27 @@ func NewServer() *Server {
 		_, _ = w.Write(srv.FaviconPNG)
 	})
 	srv.mux.Handle("/favicon.ico", http.RedirectHandler("/favicon.png", http.StatusMovedPermanently))
+	if srv.I18n != nil {
+		srv.addLocalePage()
+	}
+	if srv.Theme != nil && srv.Theme.ColorSchemeToggle {
+		srv.addColorSchemePage()
+	}
 	return srv
 }
 
+// SetTrustedProxies sets the reverse proxies (CIDRs or IPs) whose forwarding headers
+// are used to resolve client IPs, checking the given headers in order of precedence
+// (only X-Forwarded-For if none are given)
+func (srv *Server) SetTrustedProxies(trustedProxies []string, headers ...string) error {
+	res, err := NewClientIPResolver(trustedProxies, headers...)
+	if err != nil {
+		return err
+	}
+	srv.ClientIPResolver = res
+	return nil
+}
+
 // AddPage adds a new servable page to the server
 func (srv *Server) AddPage(page *Page) error {
 	return srv.AddPageWithLayout(page, srv.Layout)
@@ -57,6 +154,15 @@ func (srv *Server) AddPage(page *Page) error {
 // AddPageWithLayout adds a new servable page with custom layout to the server
 func (srv *Server) AddPageWithLayout(page *Page, layout Layout) error {
 	page.addMetadata(srv.Metadata)
+	if page.TemplateFS == nil {
+		page.TemplateFS = srv.TemplateFS
+	}
+	if srv.DevMode {
+		page.ReloadTemplate = true
+		if fsLayout, ok := layout.(*FSLayout); ok {
+			fsLayout.Reload = true
+		}
+	}
 	renderer, err := page.GetHandler(layout, srv.getContext)
 	if err != nil {
 		return err
@@ -64,6 +170,12 @@ func (srv *Server) AddPageWithLayout(page *Page, layout Layout) error {
 
 	srv.mux.Handle(page.Path, renderer)
 	srv.mux.Handle("/api"+page.Path, page.GetAPIHandler(srv.getContext))
+	if len(page.Name) > 0 {
+		srv.pages[page.Name] = page
+	}
+	if page.assetFS != nil {
+		srv.assets.add(page.Path, page.assetFS)
+	}
 	return nil
 }
 
@@ -85,12 +197,34 @@ func (srv *Server) AddServiceRate(service string, interval time.Duration, n int)
 
 // AddMiddleware adds a middleware
 func (srv *Server) AddMiddleware(middleware Middleware) {
-	srv.Middlewares = append(srv.Middlewares, middleware)
+	srv.AddMiddlewares(middleware)
 }
 
 // AddMiddlewares adds middlewares
 func (srv *Server) AddMiddlewares(middlewares ...Middleware) {
 	srv.Middlewares = append(srv.Middlewares, middlewares...)
+	for _, middleware := range middlewares {
+		srv.middlewareNames = append(srv.middlewareNames, getFuncName(middleware))
+	}
+}
+
+// AddCache adds local caches (like a Cache) that are kept in sync with the
+// caches of other replicas through the DB
+func (srv *Server) AddCache(caches ...LocalCache) error {
+	srv.caches = append(srv.caches, caches...)
+	if srv.DB == nil {
+		return nil
+	}
+	return srv.listenCaches(srv.DB, caches...)
+}
+
+// AddHub adds WebSocket hubs that broadcast to the hubs of other replicas through the DB
+func (srv *Server) AddHub(hubs ...*Hub) error {
+	srv.hubs = append(srv.hubs, hubs...)
+	if srv.DB == nil {
+		return nil
+	}
+	return srv.listenHubs(srv.DB, hubs...)
 }
 
 // ConnectDB ...
@@ -101,9 +235,56 @@ func (srv *Server) ConnectDB(redisUrl string) error {
 	}
 
 	srv.DB = db
+	caches := append([]LocalCache{srv.IPInfoCache}, srv.caches...)
+	if err := srv.listenCaches(db, caches...); err != nil {
+		return err
+	}
+	return srv.listenHubs(db, srv.hubs...)
+}
+
+func (srv *Server) listenCaches(bus InvalidationBus, caches ...LocalCache) error {
+	for _, cache := range caches {
+		stop, err := cache.Listen(bus)
+		if err != nil {
+			return err
+		}
+		srv.stopListening = append(srv.stopListening, stop)
+	}
 	return nil
 }
 
+func (srv *Server) listenHubs(bus MessageBus, hubs ...*Hub) error {
+	for _, hub := range hubs {
+		stop, err := hub.Listen(bus)
+		if err != nil {
+			return err
+		}
+		srv.stopListening = append(srv.stopListening, stop)
+	}
+	return nil
+}
+
+// Close flushes the pending request logs and traces and closes the GeoIP client and DB
+func (srv *Server) Close() error {
+	if srv.LogPipeline != nil {
+		srv.LogPipeline.Close()
+	}
+	if closer, ok := srv.GeoIPClient.(io.Closer); ok {
+		closer.Close()
+	}
+	for _, stop := range srv.stopListening {
+		stop()
+	}
+	var err error
+	if srv.DB != nil {
+		err = srv.DB.Close()
+	}
+	if tracerErr := srv.Tracer.Close(); err == nil {
+		err = tracerErr
+	}
+	return err
+}
+
 func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h := w.Header()
 	for key, values := range srv.Header {
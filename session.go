@@ -4,10 +4,11 @@ import (
 	"context"
 	"net/http"
 	"time"
-
-	"github.com/razzie/reqip"
 )
 
+// preferences are stored in the access map of the session under this access type
+const preferenceAccessType = "preference"
+
 // Session ...
 type Session struct {
 	ctx       context.Context
@@ -23,7 +24,7 @@ func newSession(r *PageRequest) *Session {
 	sess := &Session{
 		ctx:       r.Request.Context(),
 		requestID: r.RequestID,
-		ip:        reqip.GetClientIP(r.Request),
+		ip:        r.ClientIP,
 		allAccess: make(AccessMap),
 		newAccess: make(AccessMap),
 		db:        r.Context.DB,
@@ -70,6 +71,16 @@ func (sess *Session) GetAccessCode(accessType, resource string) (string, bool) {
 	return sess.allAccess.Get(accessType, resource)
 }
 
+// HasAccessType returns whether the requester has access to any resource of the given type
+func (sess *Session) HasAccessType(accessType string) bool {
+	for _, code := range sess.allAccess[AccessType(accessType)] {
+		if len(code) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // AddAccess permits the requester to access the given resource
 func (sess *Session) AddAccess(accessType, resource, accesscode string) error {
 	access := make(AccessMap)
@@ -108,6 +119,20 @@ func (sess *Session) RevokeAccess(revoke AccessRevokeMap) error {
 	return nil
 }
 
+// Preference returns a preference of the user (like the color scheme) stored in the session
+func (sess *Session) Preference(name string) string {
+	value, _ := sess.GetAccessCode(preferenceAccessType, name)
+	return value
+}
+
+// SetPreference stores a preference of the user in the session (an empty value removes it)
+func (sess *Session) SetPreference(name, value string) error {
+	if len(value) == 0 {
+		return sess.RemoveAccess(preferenceAccessType, name)
+	}
+	return sess.AddAccess(preferenceAccessType, name, value)
+}
+
 func (sess *Session) getSessionCookie(expiration time.Duration) *http.Cookie {
 	return &http.Cookie{
 		Name:    "session",
@@ -0,0 +1,144 @@
+package beepboop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHeartbeat is the default interval of the keep-alive comments of SSE streams
+var DefaultHeartbeat = 30 * time.Second
+
+// Event is a Server-Sent Event
+type Event struct {
+	ID    string        // sent back by reconnecting clients in the Last-Event-ID header
+	Event string        // event type ("message" if empty)
+	Data  interface{}   // strings and []byte are sent as is, anything else as JSON
+	Retry time.Duration // reconnection delay of the client (unchanged if 0)
+}
+
+// WithHeartbeat sets the interval of the keep-alive comments of an SSE stream
+// (no heartbeat if interval <= 0)
+func WithHeartbeat(interval time.Duration) ViewOption {
+	return func(view *View) {
+		view.heartbeat = interval
+	}
+}
+
+// SSEView returns a View that streams the events of a channel to the client until the channel
+// is closed, the client disconnects or the server shuts down.
+// Producers should stop when the request context is done.
+func SSEView(r *http.Request, events <-chan Event, opts ...ViewOption) *View {
+	v := &View{
+		StatusCode: http.StatusOK,
+		heartbeat:  DefaultHeartbeat,
+		stream:     true,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	v.renderer = func(w http.ResponseWriter) {
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache, no-transform")
+		h.Set("X-Accel-Buffering", "no")
+		h.Del("Content-Length")
+		w.WriteHeader(v.StatusCode)
+		flusher, _ := w.(http.Flusher)
+		flush := func() {
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		flush()
+
+		var heartbeat <-chan time.Time
+		if v.heartbeat > 0 {
+			ticker := time.NewTicker(v.heartbeat)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
+		shutdown := getShutdownChan(r)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-shutdown:
+				// the server waits for streams to end before shutting down
+				return
+			case <-heartbeat:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := event.write(w); err != nil {
+					return
+				}
+			}
+			flush()
+		}
+	}
+	return v
+}
+
+// SSEView returns a View that streams the events of a channel to the client until the channel
+// is closed or the client disconnects. Producers should stop when the request context is done.
+func (r *PageRequest) SSEView(events <-chan Event, opts ...ViewOption) *View {
+	return SSEView(r.Request, events, opts...)
+}
+
+// LastEventID returns the ID of the last event received by a reconnecting SSE client
+func (r *PageRequest) LastEventID() string {
+	if id := r.Request.Header.Get("Last-Event-ID"); len(id) > 0 {
+		return id
+	}
+	// used by EventSource polyfills
+	return r.Request.URL.Query().Get("lastEventId")
+}
+
+func (event *Event) write(w io.Writer) error {
+	var buf bytes.Buffer
+	if len(event.ID) > 0 {
+		fmt.Fprintf(&buf, "id: %s\n", sanitizeEventField(event.ID))
+	}
+	if len(event.Event) > 0 {
+		fmt.Fprintf(&buf, "event: %s\n", sanitizeEventField(event.Event))
+	}
+	if event.Retry > 0 {
+		fmt.Fprintf(&buf, "retry: %d\n", event.Retry.Milliseconds())
+	}
+
+	var data string
+	switch d := event.Data.(type) {
+	case nil:
+	case string:
+		data = d
+	case []byte:
+		data = string(d)
+	default:
+		jsonData, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		data = string(jsonData)
+	}
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// sanitizeEventField removes line breaks that would end the field early
+func sanitizeEventField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,90 @@
+package beepboop
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEViewWithoutHeartbeat(t *testing.T) {
+	events := make(chan Event, 2)
+	events <- Event{ID: "1", Event: "greeting", Data: "hello\nworld"}
+	events <- Event{Data: map[string]int{"n": 1}}
+	close(events)
+
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	w := httptest.NewRecorder()
+	SSEView(r, events, WithHeartbeat(0)).Render(w)
+
+	expected := "id: 1\nevent: greeting\ndata: hello\ndata: world\n\ndata: {\"n\":1}\n\n"
+	if body := w.Body.String(); body != expected {
+		t.Errorf("body = %q, expected %q", body, expected)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestSSEStreamEndsOnShutdown(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "sse.sock")
+	srv := NewServer(WithAddr("unix:" + sock))
+	srv.Logger = log.New(io.Discard, "", 0)
+	srv.ShutdownTimeout = 10 * time.Second
+	srv.AddPages(&Page{
+		Path: "/events",
+		Handler: func(pr *PageRequest) *View {
+			// the producer never ends the stream
+			return pr.SSEView(make(chan Event))
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() { runErr <- srv.Run(ctx) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			for i := 0; ; i++ {
+				conn, err := d.DialContext(ctx, "unix", sock)
+				if err == nil || i == 100 {
+					return conn, err
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		},
+	}}
+	resp, err := client.Get("http://beepboop/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the SSE stream blocks the shutdown")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %v", elapsed)
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Errorf("stream didn't end cleanly: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package beepboop
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FSLayout is a Layout loaded from an fs.FS (like embed.FS or os.DirFS)
+type FSLayout struct {
+	FS         fs.FS
+	LayoutFile string   // template defining the "layout" skeleton
+	Partials   []string // glob patterns of partials shared between pages
+	Parent     Layout   // if set, LayoutFile only overrides the blocks of the parent layout
+	Reload     bool     // re-parse the templates when they change (development mode)
+}
+
+// NewFSLayout creates a new FSLayout and validates its templates
+func NewFSLayout(fsys fs.FS, layoutFile string, partials ...string) (*FSLayout, error) {
+	l := &FSLayout{
+		FS:         fsys,
+		LayoutFile: layoutFile,
+		Partials:   partials,
+	}
+	if _, err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// BindTemplate creates a layout renderer function from a page template
+func (l *FSLayout) BindTemplate(pageTemplate string, stylesheets, scripts []string, meta map[string]string) (LayoutRenderer, error) {
+	bind := func() (LayoutRenderer, error) {
+		base, err := l.load()
+		if err != nil {
+			return nil, err
+		}
+		return base.BindTemplate(pageTemplate, stylesheets, scripts, meta)
+	}
+	if l.Reload {
+		return newReloadingRenderer(l.FS, l.files, bind)
+	}
+	return bind()
+}
+
+func (l *FSLayout) load() (Layout, error) {
+	layoutTemplate, err := fs.ReadFile(l.FS, l.LayoutFile)
+	if err != nil {
+		return nil, err
+	}
+	partials, err := readTemplateGlobs(l.FS, l.Partials)
+	if err != nil {
+		return nil, err
+	}
+	if l.Parent != nil {
+		return ExtendLayout(l.Parent, string(layoutTemplate), partials...)
+	}
+	return NewLayout(string(layoutTemplate), partials...)
+}
+
+func (l *FSLayout) files() ([]string, error) {
+	files, err := globAll(l.FS, l.Partials)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, l.LayoutFile), nil
+}
+
+func readTemplateGlobs(fsys fs.FS, patterns []string) ([]string, error) {
+	files, err := globAll(fsys, patterns)
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]string, 0, len(files))
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, string(data))
+	}
+	return templates, nil
+}
+
+func globAll(fsys fs.FS, patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// reloadingRenderer rebinds a page template when its files change
+type reloadingRenderer struct {
+	fsys      fs.FS
+	files     func() ([]string, error)
+	bind      func() (LayoutRenderer, error)
+	mtx       sync.Mutex
+	renderer  LayoutRenderer
+	err       error
+	version   string
+	lastCheck time.Time
+}
+
+func newReloadingRenderer(fsys fs.FS, files func() ([]string, error), bind func() (LayoutRenderer, error)) (LayoutRenderer, error) {
+	renderer, err := bind()
+	if err != nil {
+		return nil, err
+	}
+	rr := &reloadingRenderer{
+		fsys:     fsys,
+		files:    files,
+		bind:     bind,
+		renderer: renderer,
+	}
+	rr.version = rr.getVersion()
+	return rr.render, nil
+}
+
+func (rr *reloadingRenderer) render(w http.ResponseWriter, r *http.Request, title string, data interface{}, statusCode int) {
+	rr.mtx.Lock()
+	if time.Since(rr.lastCheck) > time.Millisecond*500 {
+		rr.lastCheck = time.Now()
+		if version := rr.getVersion(); version != rr.version {
+			rr.version = version
+			rr.renderer, rr.err = rr.bind()
+		}
+	}
+	renderer, err := rr.renderer, rr.err
+	rr.mtx.Unlock()
+
+	if err != nil {
+		handleRenderError(w, r, err, data)
+		return
+	}
+	renderer(w, r, title, data, statusCode)
+}
+
+// getVersion returns a string that changes whenever a file is modified, added or removed
+func (rr *reloadingRenderer) getVersion() string {
+	files, err := rr.files()
+	if err != nil {
+		return err.Error()
+	}
+	var version strings.Builder
+	for _, file := range files {
+		version.WriteString(file)
+		if fi, err := fs.Stat(rr.fsys, file); err == nil {
+			fmt.Fprintf(&version, ":%d:%d;", fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return version.String()
+}
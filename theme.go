@@ -0,0 +1,273 @@
+package beepboop
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DarkMode determines when the dark colors of a theme are used
+type DarkMode int
+
+// DarkMode values
+const (
+	DarkModeAuto     DarkMode = iota // follow the browser's prefers-color-scheme (or the user's choice)
+	DarkModeDisabled                 // always use the light colors
+	DarkModeAlways                   // always use the dark colors
+)
+
+// ThemeColors are CSS colors used by the default layout
+type ThemeColors struct {
+	Background string // page background
+	Panel      string // background of the content panel
+	Text       string
+	Muted      string // secondary text and link hover
+	Accent     string // link underline
+	Border     string
+	Button     string
+	Highlight  string // hovered buttons and table rows
+	Stripe     string // odd table rows
+}
+
+// Theme customizes the look of the default layout
+type Theme struct {
+	Light             ThemeColors
+	Dark              ThemeColors
+	DarkMode          DarkMode
+	ColorSchemeToggle bool   // show a light/dark toggle that is persisted in the session (see EnableColorSchemeToggle)
+	FontFamily        string // CSS font-family of the page (browser default if empty)
+	MonospaceFont     string // CSS font-family of code and pre elements
+	LogoURL           string // image shown above the page content
+	LogoAlt           string
+	LogoLink          string // where the logo points to (defaults to "/")
+	BackgroundPattern string // CSS background-image used on wide screens (none if empty)
+}
+
+// DefaultTheme is the theme of the default layout
+var DefaultTheme = Theme{
+	Light: ThemeColors{
+		Background: "white",
+		Panel:      "white",
+		Text:       "black",
+		Muted:      "dimgrey",
+		Accent:     "rgb(220, 53, 69)",
+		Border:     "black",
+		Button:     "whitesmoke",
+		Highlight:  "lightsteelblue",
+		Stripe:     "#F0F0F0",
+	},
+	Dark: ThemeColors{
+		Background: "#1E1E1E",
+		Panel:      "#2B2B2B",
+		Text:       "#E0E0E0",
+		Muted:      "darkgrey",
+		Accent:     "rgb(235, 87, 101)",
+		Border:     "#E0E0E0",
+		Button:     "#3A3A3A",
+		Highlight:  "#3D5A80",
+		Stripe:     "#333333",
+	},
+	BackgroundPattern: `url("data:image/svg+xml,%3Csvg xmlns='http://www.w3.org/2000/svg' width='4' height='4' viewBox='0 0 4 4'%3E%3Cpath fill='%23808080' fill-opacity='0.5' d='M1 3h1v1H1V3zm2-2h1v1H3V1z'%3E%3C/path%3E%3C/svg%3E")`,
+}
+
+// WithTheme sets the theme of the default layout
+func WithTheme(theme Theme) ServerOption {
+	return func(srv *Server) {
+		srv.Theme = &theme
+	}
+}
+
+// EnableColorSchemeToggle shows a light/dark toggle in the default layout
+// and adds the page that stores the user's choice in the session
+func (srv *Server) EnableColorSchemeToggle() {
+	if srv.Theme == nil {
+		theme := DefaultTheme
+		srv.Theme = &theme
+	}
+	srv.Theme.ColorSchemeToggle = true
+	srv.addColorSchemePage()
+}
+
+func (srv *Server) addColorSchemePage() {
+	if srv.colorSchemePage {
+		return
+	}
+	srv.colorSchemePage = true
+	srv.AddPages(colorSchemePage())
+}
+
+// ShowColorSchemeToggle returns whether the light/dark toggle should be shown
+func (theme *Theme) ShowColorSchemeToggle() bool {
+	return theme.ColorSchemeToggle && theme.DarkMode == DarkModeAuto
+}
+
+// CSS returns the CSS custom properties of the theme
+func (theme *Theme) CSS() template.CSS {
+	var css strings.Builder
+	switch theme.DarkMode {
+	case DarkModeDisabled:
+		writeThemeVars(&css, ":root", "light", &theme.Light)
+	case DarkModeAlways:
+		writeThemeVars(&css, ":root", "dark", &theme.Dark)
+	default:
+		writeThemeVars(&css, ":root", "light", &theme.Light)
+		writeThemeVars(&css, `:root[data-color-scheme="dark"]`, "dark", &theme.Dark)
+		css.WriteString("@media (prefers-color-scheme: dark) {\n")
+		writeThemeVars(&css, `:root:not([data-color-scheme="light"])`, "dark", &theme.Dark)
+		css.WriteString("}\n")
+	}
+
+	css.WriteString(":root {\n")
+	writeCSSVar(&css, "--bb-font", theme.FontFamily, "inherit")
+	writeCSSVar(&css, "--bb-monospace", theme.MonospaceFont, "monospace")
+	writeCSSVar(&css, "--bb-pattern", theme.BackgroundPattern, "none")
+	css.WriteString("}\n")
+	return template.CSS(css.String())
+}
+
+func writeThemeVars(css *strings.Builder, selector, scheme string, colors *ThemeColors) {
+	fmt.Fprintf(css, "%s {\n\tcolor-scheme: %s;\n", selector, scheme)
+	writeCSSVar(css, "--bb-background", colors.Background, "white")
+	writeCSSVar(css, "--bb-panel", colors.Panel, "white")
+	writeCSSVar(css, "--bb-text", colors.Text, "black")
+	writeCSSVar(css, "--bb-muted", colors.Muted, "dimgrey")
+	writeCSSVar(css, "--bb-accent", colors.Accent, "currentColor")
+	writeCSSVar(css, "--bb-border", colors.Border, "currentColor")
+	writeCSSVar(css, "--bb-button", colors.Button, "transparent")
+	writeCSSVar(css, "--bb-highlight", colors.Highlight, "transparent")
+	writeCSSVar(css, "--bb-stripe", colors.Stripe, "transparent")
+	if scheme == "dark" {
+		css.WriteString("\t--bb-to-dark: none;\n\t--bb-to-light: inline;\n")
+	} else {
+		css.WriteString("\t--bb-to-dark: inline;\n\t--bb-to-light: none;\n")
+	}
+	css.WriteString("}\n")
+}
+
+func writeCSSVar(css *strings.Builder, name, value, defaultValue string) {
+	value, ok := cssValue(value)
+	if !ok || len(value) == 0 {
+		value = defaultValue
+	}
+	fmt.Fprintf(css, "\t%s: %s;\n", name, value)
+}
+
+// cssValue checks that a value can't break out of its declaration: semicolons are only
+// allowed in strings and parentheses (like url(data:image/png;base64,...)) and braces
+// only in strings. It escapes "<" in strings so that the value can't close the style element.
+func cssValue(value string) (string, bool) {
+	var css strings.Builder
+	var quote rune
+	var escaped bool
+	var depth int
+	for _, c := range value {
+		switch {
+		case c == '\n' || c == '\r' || c == '\f':
+			return "", false
+		case c == '<':
+			if quote == 0 {
+				return "", false
+			}
+			css.WriteString(`\3c `)
+			escaped = false
+			continue
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				return "", false
+			}
+			depth--
+		case c == ';':
+			if depth == 0 {
+				return "", false
+			}
+		case c == '{' || c == '}':
+			return "", false
+		}
+		css.WriteRune(c)
+	}
+	if quote != 0 || escaped || depth > 0 {
+		return "", false
+	}
+	return css.String(), true
+}
+
+type themeKey struct{}
+
+func withTheme(r *http.Request, theme *Theme) *http.Request {
+	if theme == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), themeKey{}, theme))
+}
+
+func getTheme(r *http.Request) *Theme {
+	if theme, ok := r.Context().Value(themeKey{}).(*Theme); ok {
+		return theme
+	}
+	return &DefaultTheme
+}
+
+// GetColorScheme returns the color scheme ("light" or "dark") chosen by the user
+// or an empty string if the browser's preference should be used
+func GetColorScheme(r *http.Request) string {
+	if !getTheme(r).ShowColorSchemeToggle() {
+		return ""
+	}
+	pr := getPageRequest(r)
+	if pr == nil {
+		return ""
+	}
+	switch scheme := pr.Session().Preference("color-scheme"); scheme {
+	case "light", "dark":
+		return scheme
+	}
+	return ""
+}
+
+// colorSchemePage stores the color scheme chosen by the user (like "color-scheme?set=dark")
+// in the session and redirects back to the referring page
+func colorSchemePage() *Page {
+	handler := func(pr *PageRequest) *View {
+		scheme := pr.Request.FormValue("set")
+		if scheme != "light" && scheme != "dark" {
+			scheme = ""
+		}
+		if err := pr.Session().SetPreference("color-scheme", scheme); err != nil {
+			pr.Log(err)
+		}
+		return pr.RedirectView(referrerPath(pr.Request))
+	}
+	return &Page{
+		Path:           "/color-scheme",
+		Handler:        handler,
+		OnlyLogOnError: true,
+	}
+}
+
+// redirectBack redirects to the referring page if it's on the same host
+func redirectBack(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, referrerPath(r), http.StatusSeeOther)
+}
+
+// referrerPath returns the referring page if it's on the same host or "/"
+func referrerPath(r *http.Request) string {
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host {
+		return ref.RequestURI()
+	}
+	return "/"
+}
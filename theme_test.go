@@ -0,0 +1,69 @@
+package beepboop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSSValue(t *testing.T) {
+	for value, expected := range map[string]string{
+		"#1E1E1E":                 "#1E1E1E",
+		`"Fira Sans", sans-serif`: `"Fira Sans", sans-serif`,
+		"url(data:image/png;base64,iVBORw0KGgo=)": "url(data:image/png;base64,iVBORw0KGgo=)",
+		`url("data:image/svg+xml;utf8,<svg/>")`:   `url("data:image/svg+xml;utf8,\3c svg/>")`,
+		`"a;b{c}"`:                                `"a;b{c}"`,
+		DefaultTheme.BackgroundPattern:            DefaultTheme.BackgroundPattern,
+	} {
+		if value, ok := cssValue(value); !ok || value != expected {
+			t.Errorf("cssValue = %q, %v, expected %q", value, ok, expected)
+		}
+	}
+	for _, value := range []string{
+		"red; background: blue",
+		"red} body {display: none",
+		"red</style><script>",
+		`"unterminated`,
+		"url(a",
+		"a)",
+		`"a\"`,
+		"url(x;{})",
+	} {
+		if _, ok := cssValue(value); ok {
+			t.Errorf("cssValue(%q) should be invalid", value)
+		}
+	}
+}
+
+func TestColorSchemeToggle(t *testing.T) {
+	srv := NewServer()
+	srv.AddPages(&Page{Path: "/page"})
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/color-scheme?set=dark", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/color-scheme is registered without the toggle (status %d)", w.Code)
+	}
+
+	srv.EnableColorSchemeToggle()
+	srv.EnableColorSchemeToggle()
+	req := httptest.NewRequest(http.MethodGet, "/color-scheme?set=dark", nil)
+	req.Header.Set("Referer", "http://example.com/page")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/page" {
+		t.Fatalf("status = %d, Location = %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "preference-color-scheme" || cookies[0].Value != "dark" {
+		t.Fatalf("unexpected session cookies: %v", cookies)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `data-color-scheme="dark"`) {
+		t.Error("the layout doesn't use the color scheme of the session")
+	}
+}
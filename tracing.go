@@ -0,0 +1,373 @@
+package beepboop
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace (W3C trace-context compatible)
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the hex representation of the trace ID
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid returns whether the trace ID is non-zero
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// String returns the hex representation of the span ID
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid returns whether the span ID is non-zero
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanKind describes the relationship between the span and its parent
+type SpanKind int
+
+// span kinds (values match the OTLP specification)
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// SpanContext is the propagated part of a span
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Span is a timed operation within a trace
+type Span struct {
+	SpanContext
+	ParentSpanID SpanID
+	Name         string
+	Kind         SpanKind
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]interface{}
+	Err          error
+	tracer       *Tracer
+	mtx          sync.Mutex
+	ended        bool
+}
+
+// SetAttribute sets an attribute of the span
+func (span *Span) SetAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+	span.mtx.Lock()
+	defer span.mtx.Unlock()
+	if span.Attributes == nil {
+		span.Attributes = make(map[string]interface{})
+	}
+	span.Attributes[key] = value
+}
+
+// SetError marks the span as failed
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.mtx.Lock()
+	defer span.mtx.Unlock()
+	span.Err = err
+}
+
+// End finishes the span and hands it over to the tracer's exporter
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.mtx.Lock()
+	if span.ended {
+		span.mtx.Unlock()
+		return
+	}
+	span.ended = true
+	span.EndTime = time.Now()
+	span.mtx.Unlock()
+	if span.Sampled {
+		span.tracer.enqueue(span)
+	}
+}
+
+// TraceParent returns the W3C traceparent header value of the span
+func (span *Span) TraceParent() string {
+	if span == nil {
+		return ""
+	}
+	return formatTraceParent(span.SpanContext)
+}
+
+// SpanExporter sends finished spans to a tracing backend
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, serviceName string, spans []*Span) error
+}
+
+// Tracer creates spans and exports them in batches
+type Tracer struct {
+	ServiceName   string
+	Logger        *log.Logger
+	exporter      SpanExporter
+	queue         chan *Span
+	flush         chan chan struct{}
+	done          chan struct{}
+	closeOnce     sync.Once
+	batchSize     int
+	batchInterval time.Duration
+}
+
+// NewTracer returns a new Tracer that exports spans using the given exporter
+func NewTracer(serviceName string, exporter SpanExporter) *Tracer {
+	t := &Tracer{
+		ServiceName:   serviceName,
+		exporter:      exporter,
+		queue:         make(chan *Span, 2048),
+		flush:         make(chan chan struct{}),
+		done:          make(chan struct{}),
+		batchSize:     512,
+		batchInterval: time.Second * 5,
+	}
+	go t.run()
+	return t
+}
+
+// StartSpan starts a new span as a child of the span in ctx (if any)
+func (t *Tracer) StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		SpanContext: SpanContext{Sampled: true},
+		Name:        name,
+		Kind:        kind,
+		StartTime:   time.Now(),
+		tracer:      t,
+	}
+	if parent, ok := ctx.Value(spanContextKey).(SpanContext); ok {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+		span.Sampled = parent.Sampled
+	} else {
+		rand.Read(span.TraceID[:])
+	}
+	rand.Read(span.SpanID[:])
+	ctx = context.WithValue(ctx, spanKey, span)
+	ctx = context.WithValue(ctx, spanContextKey, span.SpanContext)
+	return ctx, span
+}
+
+// StartRequestSpan starts a server span for an incoming request,
+// continuing the trace from the request's traceparent header if present
+func (t *Tracer) StartRequestSpan(r *http.Request, name string) (context.Context, *Span) {
+	ctx := r.Context()
+	if sc, ok := parseTraceParent(r.Header.Get("traceparent")); ok {
+		ctx = context.WithValue(ctx, spanContextKey, sc)
+	}
+	ctx, span := t.StartSpan(ctx, name, SpanKindServer)
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.RequestURI())
+	span.SetAttribute("http.user_agent", r.UserAgent())
+	return ctx, span
+}
+
+// Flush waits until all queued spans are exported
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+		<-flushed
+	case <-t.done:
+	}
+}
+
+// Close flushes the remaining spans and stops the tracer
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.closeOnce.Do(func() {
+		t.Flush()
+		close(t.done)
+	})
+	return nil
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case <-t.done:
+	case t.queue <- span:
+	default:
+		// queue is full, drop the span rather than blocking the request
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(t.batchInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, t.batchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if t.exporter != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			if err := t.exporter.ExportSpans(ctx, t.ServiceName, batch); err != nil && t.Logger != nil {
+				t.Logger.Print("failed to export spans: ", err)
+			}
+			cancel()
+		}
+		batch = make([]*Span, 0, t.batchSize)
+	}
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= t.batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			for drained := false; !drained; {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+			export()
+			close(flushed)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+type spanKeyType struct{}
+type spanContextKeyType struct{}
+
+var (
+	spanKey        = &spanKeyType{}
+	spanContextKey = &spanContextKeyType{}
+)
+
+// SpanFromContext returns the current span or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// StartSpan starts a child span of the current span in ctx
+// or returns a nil span (which is safe to use) if tracing is disabled
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if parent := SpanFromContext(ctx); parent != nil {
+		return parent.tracer.StartSpan(ctx, name, kind)
+	}
+	return ctx, nil
+}
+
+// InjectTraceContext adds the traceparent header of the current span in ctx
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	if sc, ok := ctx.Value(spanContextKey).(SpanContext); ok {
+		header.Set("traceparent", formatTraceParent(sc))
+	}
+}
+
+// TracingTransport is a http.RoundTripper that creates client spans
+// and propagates the trace context to outgoing requests
+type TracingTransport struct {
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *TracingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, span := StartSpan(r.Context(), "HTTP "+r.Method, SpanKindClient)
+	if span == nil {
+		return base.RoundTrip(r)
+	}
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.url", r.URL.String())
+	r = r.Clone(ctx)
+	InjectTraceContext(ctx, r.Header)
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	return resp, nil
+}
+
+func formatTraceParent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+func parseTraceParent(header string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// detachedContext keeps the values of its parent but not its deadline and cancelation
+type detachedContext struct {
+	context.Context
+	values context.Context
+}
+
+func detachContext(ctx context.Context) context.Context {
+	return detachedContext{Context: context.Background(), values: ctx}
+}
+
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.values.Value(key)
+}
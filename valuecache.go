@@ -0,0 +1,319 @@
+package beepboop
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCacheMiss is returned when a value isn't cached
+var ErrCacheMiss = errors.New("cache miss")
+
+// ErrNotFound can be returned (or wrapped) by loaders to cache the absence of a value
+// for the NegativeTTL of the Cache
+var ErrNotFound = errors.New("not found")
+
+// Cache is a typed in-memory LRU cache of values that can optionally persist entries in DB
+// (making it a two-tier cache). Concurrent loads of the same key are deduplicated.
+// Replicas sharing a DB should Listen to it to evict the entries changed by each other.
+type Cache[T any] struct {
+	Name        string        // namespace of the keys in DB
+	LocalTTL    time.Duration // values are kept in memory for at most this long (no limit if 0)
+	NegativeTTL time.Duration // ErrNotFound results of loaders are cached this long (not cached if 0)
+	mtx         sync.Mutex
+	size        int
+	lru         *list.List
+	entries     map[string]*list.Element
+	tags        map[string]map[string]bool
+	calls       map[string]*cacheCall[T]
+	listener    invalidationListener
+}
+
+// cacheEnvelope is a cached value or the error message of a not found value
+type cacheEnvelope[T any] struct {
+	Value    T         `json:"value"`
+	NotFound string    `json:"not_found,omitempty"`
+	Expires  time.Time `json:"expires"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+type cacheEntry[T any] struct {
+	key     string
+	env     *cacheEnvelope[T]
+	expires time.Time
+}
+
+type cacheCall[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+type notFoundError struct {
+	msg string
+}
+
+func (err *notFoundError) Error() string { return err.msg }
+func (err *notFoundError) Unwrap() error { return ErrNotFound }
+
+// NewCache returns a new Cache that keeps up to size values in memory
+func NewCache[T any](name string, size int) *Cache[T] {
+	return &Cache[T]{
+		Name:    name,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		tags:    make(map[string]map[string]bool),
+		calls:   make(map[string]*cacheCall[T]),
+	}
+}
+
+// Get returns a cached value from memory or DB (if not nil), ErrCacheMiss if it isn't cached
+// or an error wrapping ErrNotFound if its absence is cached
+func (c *Cache[T]) Get(db *DB, key string) (T, error) {
+	env, err := c.get(db, key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return env.result()
+}
+
+// Set caches a value in memory and DB (if not nil) for ttl
+func (c *Cache[T]) Set(db *DB, key string, value T, ttl time.Duration, tags ...string) error {
+	err := c.put(db, key, &cacheEnvelope[T]{
+		Value:   value,
+		Expires: time.Now().Add(ttl),
+		Tags:    tags,
+	})
+	if err != nil {
+		return err
+	}
+	return c.listener.publish(Invalidation{Cache: c.Name, Keys: []string{key}})
+}
+
+// GetOrLoad returns a cached value or loads and caches it for ttl if it isn't cached yet.
+// Only one loader runs at a time for the same key, concurrent callers wait for its result.
+func (c *Cache[T]) GetOrLoad(db *DB, key string, ttl time.Duration, loader func() (T, error), tags ...string) (T, error) {
+	if env, err := c.get(db, key); err == nil {
+		return env.result()
+	}
+
+	c.mtx.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mtx.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &cacheCall[T]{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mtx.Unlock()
+
+	defer func() {
+		c.mtx.Lock()
+		delete(c.calls, key)
+		c.mtx.Unlock()
+		close(call.done)
+	}()
+
+	func() {
+		// waiters get an error instead of a zero value if the loader panics
+		defer func() {
+			if p := recover(); p != nil {
+				call.err = fmt.Errorf("cache %s: loader of %q panicked: %v", c.Name, key, p)
+				panic(p)
+			}
+		}()
+		call.value, call.err = loader()
+	}()
+	switch {
+	case call.err == nil:
+		c.put(db, key, &cacheEnvelope[T]{
+			Value:   call.value,
+			Expires: time.Now().Add(ttl),
+			Tags:    tags,
+		})
+	case errors.Is(call.err, ErrNotFound) && c.NegativeTTL > 0:
+		c.put(db, key, &cacheEnvelope[T]{
+			NotFound: call.err.Error(),
+			Expires:  time.Now().Add(c.NegativeTTL),
+			Tags:     tags,
+		})
+		call.err = &notFoundError{msg: call.err.Error()}
+	}
+	return call.value, call.err
+}
+
+// Delete removes values from memory and DB (if not nil)
+func (c *Cache[T]) Delete(db *DB, keys ...string) error {
+	return c.invalidate(db, Invalidation{Cache: c.Name, Keys: keys})
+}
+
+// InvalidateTags removes the values that have any of the given tags from memory and DB (if not nil)
+func (c *Cache[T]) InvalidateTags(db *DB, tags ...string) error {
+	return c.invalidate(db, Invalidation{Cache: c.Name, Tags: tags})
+}
+
+// InvalidatePrefix removes the values whose key starts with prefix from memory and DB (if not nil)
+func (c *Cache[T]) InvalidatePrefix(db *DB, prefix string) error {
+	return c.invalidate(db, Invalidation{Cache: c.Name, Prefix: prefix})
+}
+
+// Listen evicts the entries invalidated by the caches of other replicas
+// (with the same name) and publishes the invalidations of this cache to them
+func (c *Cache[T]) Listen(bus InvalidationBus) (func(), error) {
+	return c.listener.listen(bus, c.Name, c.evict)
+}
+
+func (c *Cache[T]) invalidate(db *DB, inv Invalidation) error {
+	c.evict(inv)
+	if db != nil {
+		var err error
+		switch {
+		case len(inv.Keys) > 0:
+			err = db.deleteCacheKeys(c.dbKeys(inv.Keys)...)
+		case len(inv.Tags) > 0:
+			err = db.invalidateTags("beepboop-cachetag:", "beepboop-cache:", c.dbKeys(inv.Tags)...)
+		case len(inv.Prefix) > 0:
+			err = db.deleteCachePrefix(c.dbKeys([]string{inv.Prefix})[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return c.listener.publish(inv)
+}
+
+// evict removes the invalidated entries from memory
+func (c *Cache[T]) evict(inv Invalidation) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, key := range inv.Keys {
+		if elem, ok := c.entries[key]; ok {
+			c.remove(elem)
+		}
+	}
+	for _, tag := range inv.Tags {
+		for key := range c.tags[tag] {
+			if elem, ok := c.entries[key]; ok {
+				c.remove(elem)
+			}
+		}
+		delete(c.tags, tag)
+	}
+	if len(inv.Prefix) > 0 {
+		for key, elem := range c.entries {
+			if strings.HasPrefix(key, inv.Prefix) {
+				c.remove(elem)
+			}
+		}
+	}
+}
+
+func (c *Cache[T]) dbKeys(keys []string) []string {
+	if len(c.Name) == 0 {
+		return keys
+	}
+	dbKeys := make([]string, len(keys))
+	for i, key := range keys {
+		dbKeys[i] = c.Name + ":" + key
+	}
+	return dbKeys
+}
+
+func (c *Cache[T]) get(db *DB, key string) (*cacheEnvelope[T], error) {
+	if env := c.getLocal(key); env != nil {
+		return env, nil
+	}
+	if db == nil {
+		return nil, ErrCacheMiss
+	}
+	data, err := db.getCacheEntry(c.dbKeys([]string{key})[0])
+	if err != nil {
+		return nil, err
+	}
+	var env cacheEnvelope[T]
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	if time.Now().After(env.Expires) {
+		return nil, ErrCacheMiss
+	}
+	c.putLocal(key, &env)
+	return &env, nil
+}
+
+func (c *Cache[T]) put(db *DB, key string, env *cacheEnvelope[T]) error {
+	c.putLocal(key, env)
+	if db == nil {
+		return nil
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return db.putCacheEntry(c.dbKeys([]string{key})[0], data, time.Until(env.Expires), c.dbKeys(env.Tags)...)
+}
+
+func (c *Cache[T]) getLocal(key string) *cacheEnvelope[T] {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry[T])
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry.env
+}
+
+func (c *Cache[T]) putLocal(key string, env *cacheEnvelope[T]) {
+	expires := env.Expires
+	if c.LocalTTL > 0 && time.Now().Add(c.LocalTTL).Before(expires) {
+		expires = time.Now().Add(c.LocalTTL)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry[T]{key: key, env: env, expires: expires})
+	for _, tag := range env.Tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]bool)
+		}
+		c.tags[tag][key] = true
+	}
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache[T]) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry[T])
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	for _, tag := range entry.env.Tags {
+		delete(c.tags[tag], entry.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+func (env *cacheEnvelope[T]) result() (T, error) {
+	if len(env.NotFound) > 0 {
+		var zero T
+		return zero, &notFoundError{msg: env.NotFound}
+	}
+	return env.Value, nil
+}
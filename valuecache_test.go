@@ -0,0 +1,54 @@
+package beepboop
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoadPanic(t *testing.T) {
+	c := NewCache[string]("test", 16)
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	waiterErr := make(chan error)
+
+	go func() {
+		defer func() { recover() }()
+		c.GetOrLoad(nil, "key", time.Minute, func() (string, error) {
+			close(loading)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-loading
+	go func() {
+		_, err := c.GetOrLoad(nil, "key", time.Minute, func() (string, error) {
+			return "value", nil
+		})
+		waiterErr <- err
+	}()
+	// give the second caller time to join the running load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err := <-waiterErr; err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("waiter got %v, expected the loader's panic", err)
+	}
+
+	// the panic is not cached
+	value, err := c.GetOrLoad(nil, "key", time.Minute, func() (string, error) {
+		return "value", nil
+	})
+	if err != nil || value != "value" {
+		t.Errorf("GetOrLoad = %q, %v", value, err)
+	}
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("recovered %v, expected the loader's panic", p)
+		}
+	}()
+	c.GetOrLoad(nil, "other", time.Minute, func() (string, error) {
+		panic("boom")
+	})
+}
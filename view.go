@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 // View is something that a PageHandler returns and is capable of rendering a page
@@ -16,50 +17,57 @@ type View struct {
 	Redirect   string
 	header     http.Header
 	cookies    []*http.Cookie
+	triggers   map[string]interface{}
+	cacheTags  []string
+	noCache    bool
+	heartbeat  time.Duration // of SSE streams
+	stream     bool          // rendered the same way for API requests
 	renderer   func(w http.ResponseWriter)
 	closer     func() error
 }
 
 // Render renders the view
 func (view *View) Render(w http.ResponseWriter) {
-	h := w.Header()
-	for key, values := range view.header {
-		key = http.CanonicalHeaderKey(key)
-		h[key] = append(h[key], values...)
-	}
-	for _, cookie := range view.cookies {
-		http.SetCookie(w, cookie)
-	}
+	view.writeHeader(w)
 	view.renderer(w)
 }
 
-// RenderAPIResponse renders the API response of the view
+// RenderAPIResponse renders the API response of the view.
+// Slices and channels in Data are streamed as JSON arrays.
 func (view *View) RenderAPIResponse(w http.ResponseWriter) {
-	h := w.Header()
-	for key, values := range view.header {
-		key = http.CanonicalHeaderKey(key)
-		h[key] = append(h[key], values...)
-	}
-	for _, cookie := range view.cookies {
-		http.SetCookie(w, cookie)
-	}
-	w.WriteHeader(view.StatusCode)
+	view.renderAPIResponse(w, nil)
+}
+
+// renderAPIResponse streams slices and channels as NDJSON if the request accepts it
+// and stops receiving from channels when the request is done
+func (view *View) renderAPIResponse(w http.ResponseWriter, r *http.Request) {
+	view.writeHeader(w)
 
 	if view.Error != nil {
+		w.WriteHeader(view.StatusCode)
 		w.Write([]byte(view.Error.Error()))
 		return
 	}
 
 	if view.Data != nil {
+		if stream := newJSONStream(view.Data, r); stream != nil {
+			stream.render(w, view.StatusCode)
+			return
+		}
 		data, err := json.MarshalIndent(view.Data, "", "\t")
 		if err != nil {
+			w.WriteHeader(view.StatusCode)
 			w.Write([]byte(err.Error()))
 			return
 		}
+		setDefaultContentType(w, "application/json; charset=utf-8")
+		w.WriteHeader(view.StatusCode)
 		w.Write(data)
 		return
 	}
 
+	w.WriteHeader(view.StatusCode)
+
 	if view.StatusCode == http.StatusOK {
 		w.Write([]byte("OK"))
 		return
@@ -68,6 +76,20 @@ func (view *View) RenderAPIResponse(w http.ResponseWriter) {
 	w.Write([]byte(http.StatusText(view.StatusCode)))
 }
 
+func (view *View) writeHeader(w http.ResponseWriter) {
+	h := w.Header()
+	for key, values := range view.header {
+		key = http.CanonicalHeaderKey(key)
+		h[key] = append(h[key], values...)
+	}
+	if triggers := view.triggerHeader(); len(triggers) > 0 {
+		h.Set("HX-Trigger", triggers)
+	}
+	for _, cookie := range view.cookies {
+		http.SetCookie(w, cookie)
+	}
+}
+
 // Close frees resources used by the view
 func (view *View) Close() error {
 	if view.closer != nil {
@@ -201,9 +223,7 @@ func CopyView(resp *http.Response, opts ...ViewOption) *View {
 	}
 	bytes, _ := ioutil.ReadAll(resp.Body)
 	v.renderer = func(w http.ResponseWriter) {
-		for k, v := range resp.Header {
-			w.Header().Set(k, v[0])
-		}
+		copyHeader(w.Header(), resp.Header)
 		w.WriteHeader(v.StatusCode)
 		w.Write(bytes)
 	}
@@ -226,9 +246,7 @@ func AsyncCopyView(resp *http.Response, opts ...ViewOption) *View {
 		opt(v)
 	}
 	v.renderer = func(w http.ResponseWriter) {
-		for k, v := range resp.Header {
-			w.Header().Set(k, v[0])
-		}
+		copyHeader(w.Header(), resp.Header)
 		w.WriteHeader(v.StatusCode)
 		io.Copy(w, resp.Body)
 	}
@@ -0,0 +1,491 @@
+package beepboop
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a WebSocket message
+type MessageType int
+
+// WebSocket message types
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// WebSocket close codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessageSize is the size limit of received messages if MaxMessageSize isn't set
+const maxWebSocketMessageSize = 32 << 20
+
+// CloseError is returned by ReadMessage when the connection was closed by a close frame
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (err *CloseError) Error() string {
+	if len(err.Reason) > 0 {
+		return fmt.Sprintf("websocket closed: %d %s", err.Code, err.Reason)
+	}
+	return fmt.Sprintf("websocket closed: %d", err.Code)
+}
+
+// ErrWebSocketClosed is returned when writing to a closed WebSocket
+var ErrWebSocketClosed = errors.New("websocket closed")
+
+// WebSocket is a server side WebSocket connection (RFC 6455).
+// The handler must keep reading messages for pings and close frames to be handled.
+type WebSocket struct {
+	Request        *PageRequest
+	Subprotocol    string        // negotiated subprotocol (if any)
+	MaxMessageSize int64         // larger messages close the connection with CloseMessageTooBig (32MB if <= 0)
+	PingInterval   time.Duration // the connection is closed if the client doesn't respond in PingInterval+PongTimeout
+	PongTimeout    time.Duration
+	WriteTimeout   time.Duration
+	subprotocols   []string
+	checkOrigin    func(r *http.Request) bool
+	conn           net.Conn
+	br             *bufio.Reader
+	wmtx           sync.Mutex
+	ctx            context.Context
+	cancel         context.CancelFunc
+	closeOnce      sync.Once
+}
+
+// WebSocketOption is used to customize the WebSocket created by Upgrade
+type WebSocketOption func(ws *WebSocket)
+
+// WithMaxMessageSize sets the size limit of received messages
+func WithMaxMessageSize(size int64) WebSocketOption {
+	return func(ws *WebSocket) {
+		ws.MaxMessageSize = size
+	}
+}
+
+// WithPingInterval sets how often the client is pinged and how long to wait for the pong
+// (pings are disabled if interval is 0)
+func WithPingInterval(interval, timeout time.Duration) WebSocketOption {
+	return func(ws *WebSocket) {
+		ws.PingInterval = interval
+		ws.PongTimeout = timeout
+	}
+}
+
+// WithSubprotocols sets the supported subprotocols in order of preference
+func WithSubprotocols(protocols ...string) WebSocketOption {
+	return func(ws *WebSocket) {
+		ws.subprotocols = protocols
+	}
+}
+
+// WithOriginCheck replaces the default check that only accepts same origin requests
+// (and requests without an Origin header)
+func WithOriginCheck(check func(r *http.Request) bool) WebSocketOption {
+	return func(ws *WebSocket) {
+		ws.checkOrigin = check
+	}
+}
+
+// Upgrade returns a View that upgrades the connection to a WebSocket and calls handler with it.
+// The WebSocket is closed when handler returns. Middlewares, sessions and rate limiters
+// work the same way as for any other request, and cookies of the view are sent in the handshake.
+func (r *PageRequest) Upgrade(handler func(ws *WebSocket), opts ...WebSocketOption) *View {
+	ws := &WebSocket{
+		Request:        r,
+		MaxMessageSize: 1 << 20,
+		PingInterval:   30 * time.Second,
+		PongTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		checkOrigin:    isSameOrigin,
+	}
+	for _, opt := range opts {
+		opt(ws)
+	}
+	if status, err := checkWebSocketHandshake(r.Request); err != nil {
+		v := r.ErrorView(err.Error(), status)
+		if status == http.StatusUpgradeRequired {
+			WithHeader("Sec-WebSocket-Version", "13")(v)
+		}
+		return v
+	}
+	if !ws.checkOrigin(r.Request) {
+		return r.ErrorView("Origin not allowed", http.StatusForbidden)
+	}
+	v := &View{
+		StatusCode: http.StatusSwitchingProtocols,
+		stream:     true,
+		noCache:    true,
+	}
+	v.renderer = func(w http.ResponseWriter) {
+		if err := ws.handshake(w); err != nil {
+			r.Log("websocket handshake failed: ", err)
+			return
+		}
+		defer ws.Close()
+		go ws.keepalive()
+		handler(ws)
+	}
+	return v
+}
+
+func checkWebSocketHandshake(r *http.Request) (int, error) {
+	if r.Method != http.MethodGet {
+		return http.StatusMethodNotAllowed, errors.New("WebSocket handshake must be a GET request")
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return http.StatusBadRequest, errors.New("Not a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return http.StatusUpgradeRequired, errors.New("Unsupported WebSocket version")
+	}
+	if key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key")); err != nil || len(key) != 16 {
+		return http.StatusBadRequest, errors.New("Invalid Sec-WebSocket-Key")
+	}
+	return 0, nil
+}
+
+func isSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ws *WebSocket) handshake(w http.ResponseWriter) error {
+	r := ws.Request.Request
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return errors.New("connection can't be hijacked")
+	}
+	for _, protocol := range ws.subprotocols {
+		if headerContainsToken(r.Header, "Sec-WebSocket-Protocol", protocol) {
+			ws.Subprotocol = protocol
+			break
+		}
+	}
+
+	h := w.Header().Clone()
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return err
+	}
+	ws.conn = conn
+	ws.br = brw.Reader
+	conn.SetDeadline(time.Time{}) // clear the deadlines of the HTTP server
+	ws.ctx, ws.cancel = context.WithCancel(detachContext(r.Context()))
+
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+	if len(ws.Subprotocol) > 0 {
+		h.Set("Sec-WebSocket-Protocol", ws.Subprotocol)
+	}
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Vary", "Cache-Control"} {
+		h.Del(key)
+	}
+
+	var resp strings.Builder
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	h.Write(&resp)
+	resp.WriteString("\r\n")
+	conn.SetWriteDeadline(time.Now().Add(ws.WriteTimeout))
+	if _, err := io.WriteString(conn, resp.String()); err != nil {
+		ws.closeConn()
+		return err
+	}
+	return nil
+}
+
+// Context returns a context that is done when the connection is closed
+func (ws *WebSocket) Context() context.Context {
+	return ws.ctx
+}
+
+// ReadMessage reads the next message and handles the control frames before it
+func (ws *WebSocket) ReadMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var message []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame(int64(len(message)))
+		if err != nil {
+			return 0, nil, ws.fail(err)
+		}
+		switch opcode {
+		case opPing:
+			ws.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, ws.closed(payload)
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, ws.fail(&CloseError{Code: CloseProtocolError, Reason: "unexpected continuation frame"})
+			}
+			message = append(message, payload...)
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, ws.fail(&CloseError{Code: CloseProtocolError, Reason: "expected continuation frame"})
+			}
+			typ = MessageType(opcode)
+			message = payload
+		default:
+			return 0, nil, ws.fail(&CloseError{Code: CloseProtocolError, Reason: "unknown opcode"})
+		}
+		if !fin {
+			continue
+		}
+		if typ == TextMessage && !utf8.Valid(message) {
+			return 0, nil, ws.fail(&CloseError{Code: CloseInvalidPayload, Reason: "invalid UTF-8"})
+		}
+		return typ, message, nil
+	}
+}
+
+// ReadJSON reads the next message and unmarshals it into v
+func (ws *WebSocket) ReadJSON(v interface{}) error {
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage sends a message
+func (ws *WebSocket) WriteMessage(typ MessageType, data []byte) error {
+	return ws.writeFrame(byte(typ), data)
+}
+
+// WriteJSON sends v as a JSON text message
+func (ws *WebSocket) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(TextMessage, data)
+}
+
+// Close closes the connection normally
+func (ws *WebSocket) Close() error {
+	return ws.CloseWithReason(CloseNormal, "")
+}
+
+// CloseWithReason sends a close frame with the given code and reason and closes the connection
+func (ws *WebSocket) CloseWithReason(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	err := ws.writeFrame(opClose, payload)
+	ws.closeConn()
+	if err == ErrWebSocketClosed {
+		return nil
+	}
+	return err
+}
+
+func (ws *WebSocket) readFrame(messageSize int64) (fin bool, opcode byte, payload []byte, err error) {
+	if ws.PingInterval > 0 {
+		ws.conn.SetReadDeadline(time.Now().Add(ws.PingInterval + ws.PongTimeout))
+	}
+	var head [2]byte
+	if _, err = io.ReadFull(ws.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0f
+	if head[0]&0x70 != 0 {
+		err = &CloseError{Code: CloseProtocolError, Reason: "reserved bits set"}
+		return
+	}
+	if head[1]&0x80 == 0 {
+		err = &CloseError{Code: CloseProtocolError, Reason: "unmasked client frame"}
+		return
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		if ext[0]&0x80 != 0 {
+			err = &CloseError{Code: CloseProtocolError, Reason: "invalid frame length"}
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if opcode >= opClose {
+		if !fin || length > 125 {
+			err = &CloseError{Code: CloseProtocolError, Reason: "invalid control frame"}
+			return
+		}
+	} else if length > ws.maxMessageSize()-messageSize {
+		err = &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+func (ws *WebSocket) maxMessageSize() int64 {
+	if ws.MaxMessageSize <= 0 {
+		return maxWebSocketMessageSize
+	}
+	return ws.MaxMessageSize
+}
+
+func (ws *WebSocket) writeFrame(opcode byte, payload []byte) error {
+	ws.wmtx.Lock()
+	defer ws.wmtx.Unlock()
+	if ws.ctx.Err() != nil {
+		return ErrWebSocketClosed
+	}
+
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(append(frame, 127), ext[:]...)
+	}
+	frame = append(frame, payload...)
+	if ws.WriteTimeout > 0 {
+		ws.conn.SetWriteDeadline(time.Now().Add(ws.WriteTimeout))
+	}
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+// keepalive pings the client until the connection is closed
+func (ws *WebSocket) keepalive() {
+	if ws.PingInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(ws.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ws.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ws.writeFrame(opPing, nil); err != nil {
+				ws.closeConn()
+				return
+			}
+		}
+	}
+}
+
+// closed answers the close frame of the client
+func (ws *WebSocket) closed(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+	}
+	ws.writeFrame(opClose, payload)
+	ws.closeConn()
+	return closeErr
+}
+
+// fail closes the connection because of a read error
+func (ws *WebSocket) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		ws.CloseWithReason(closeErr.Code, closeErr.Reason)
+		return err
+	}
+	ws.closeConn()
+	return err
+}
+
+func (ws *WebSocket) closeConn() {
+	ws.closeOnce.Do(func() {
+		ws.wmtx.Lock()
+		ws.cancel()
+		ws.wmtx.Unlock()
+		ws.conn.Close()
+	})
+}
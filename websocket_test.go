@@ -0,0 +1,308 @@
+package beepboop
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newWebSocketTestServer(t *testing.T, hub *Hub) *httptest.Server {
+	srv := NewServer()
+	srv.Logger = log.New(io.Discard, "", 0)
+	echo := func(ws *WebSocket) {
+		for {
+			typ, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(typ, msg)
+		}
+	}
+	srv.AddPages(
+		&Page{
+			Path: "/echo",
+			Handler: func(pr *PageRequest) *View {
+				return pr.Upgrade(echo, WithMaxMessageSize(16), WithPingInterval(0, 0))
+			},
+		},
+		&Page{
+			Path: "/unlimited",
+			Handler: func(pr *PageRequest) *View {
+				return pr.Upgrade(echo, WithMaxMessageSize(0), WithPingInterval(0, 0))
+			},
+		},
+		&Page{
+			Path: "/hub",
+			Handler: func(pr *PageRequest) *View {
+				return pr.Upgrade(func(ws *WebSocket) {
+					hub.Join("room", ws)
+					echo(ws)
+				}, WithPingInterval(0, 0))
+			},
+		},
+	)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// testWebSocketClient is a minimal client side of the WebSocket protocol
+type testWebSocketClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialTestWebSocket(t *testing.T, ts *httptest.Server, path string) *testWebSocketClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: "+ts.Listener.Addr().String()+"\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake: status %d, headers %v", resp.StatusCode, resp.Header)
+	}
+	return &testWebSocketClient{t: t, conn: conn, br: br}
+}
+
+// writeFrame writes a masked frame (or an unmasked one if masked is false)
+func (c *testWebSocketClient) writeFrame(fin bool, opcode byte, payload []byte, masked bool) {
+	c.writeHeader(fin, opcode, uint64(len(payload)), masked)
+	mask := []byte{1, 2, 3, 4}
+	data := make([]byte, len(payload))
+	for i := range payload {
+		data[i] = payload[i]
+		if masked {
+			data[i] ^= mask[i%4]
+		}
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// writeHeader writes the header of a frame (with a mask if masked is true) without its payload
+func (c *testWebSocketClient) writeHeader(fin bool, opcode byte, length uint64, masked bool) {
+	head := []byte{opcode, 0}
+	if fin {
+		head[0] |= 0x80
+	}
+	if masked {
+		head[1] = 0x80
+	}
+	switch {
+	case length <= 125:
+		head[1] |= byte(length)
+	case length <= 0xffff:
+		head[1] |= 126
+		head = append(head, byte(length>>8), byte(length))
+	default:
+		head[1] |= 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], length)
+		head = append(head, ext[:]...)
+	}
+	if masked {
+		head = append(head, 1, 2, 3, 4)
+	}
+	if _, err := c.conn.Write(head); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testWebSocketClient) readFrame() (byte, []byte) {
+	c.t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	length := int(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+// expectClose reads a close frame with the given code and checks that the connection is closed
+func (c *testWebSocketClient) expectClose(code int) {
+	c.t.Helper()
+	opcode, payload := c.readFrame()
+	if opcode != opClose || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != code {
+		c.t.Fatalf("expected close frame %d, got opcode %d: %q", code, opcode, payload)
+	}
+	if _, err := c.br.ReadByte(); !errors.Is(err, io.EOF) {
+		c.t.Errorf("connection not closed after the close frame: %v", err)
+	}
+}
+
+func TestWebSocketHandshakeRejected(t *testing.T) {
+	srv := NewServer()
+	srv.Logger = log.New(io.Discard, "", 0)
+	srv.AddPages(&Page{
+		Path: "/ws",
+		Handler: func(pr *PageRequest) *View {
+			return pr.Upgrade(func(ws *WebSocket) {})
+		},
+	})
+	valid := map[string]string{
+		"Connection":            "keep-alive, Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-WebSocket-Version": "13",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+	}
+	cases := []struct {
+		name   string
+		method string
+		header map[string]string
+		status int
+	}{
+		{"POST", http.MethodPost, nil, http.StatusMethodNotAllowed},
+		{"no upgrade", http.MethodGet, map[string]string{"Upgrade": ""}, http.StatusBadRequest},
+		{"old version", http.MethodGet, map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired},
+		{"invalid key", http.MethodGet, map[string]string{"Sec-WebSocket-Key": "short"}, http.StatusBadRequest},
+		{"cross origin", http.MethodGet, map[string]string{"Origin": "http://evil.example.com"}, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(tc.method, "http://example.com/ws", nil)
+		for key, value := range valid {
+			r.Header.Set(key, value)
+		}
+		for key, value := range tc.header {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, expected %d", tc.name, w.Code, tc.status)
+		}
+		if tc.status == http.StatusUpgradeRequired && w.Header().Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("%s: missing Sec-WebSocket-Version", tc.name)
+		}
+	}
+}
+
+func TestWebSocketFragmentedMessage(t *testing.T) {
+	ts := newWebSocketTestServer(t, nil)
+	c := dialTestWebSocket(t, ts, "/echo")
+
+	// control frames can be interleaved with the fragments of a message
+	c.writeFrame(false, opText, []byte("hel"), true)
+	c.writeFrame(true, opPing, []byte("ping"), true)
+	c.writeFrame(true, opContinuation, []byte("lo"), true)
+	if opcode, payload := c.readFrame(); opcode != opPong || string(payload) != "ping" {
+		t.Errorf("expected pong, got opcode %d: %q", opcode, payload)
+	}
+	if opcode, payload := c.readFrame(); opcode != opText || string(payload) != "hello" {
+		t.Errorf("expected hello, got opcode %d: %q", opcode, payload)
+	}
+
+	c.writeFrame(true, opClose, []byte{0x03, 0xe8}, true)
+	c.expectClose(CloseNormal)
+}
+
+func TestWebSocketInvalidFrames(t *testing.T) {
+	ts := newWebSocketTestServer(t, nil)
+	cases := []struct {
+		name  string
+		path  string
+		write func(c *testWebSocketClient)
+		code  int
+	}{
+		{"too big", "/echo", func(c *testWebSocketClient) {
+			c.writeFrame(true, opBinary, make([]byte, 17), true)
+		}, CloseMessageTooBig},
+		{"too big fragments", "/echo", func(c *testWebSocketClient) {
+			c.writeFrame(false, opBinary, make([]byte, 10), true)
+			c.writeFrame(true, opContinuation, make([]byte, 7), true)
+		}, CloseMessageTooBig},
+		{"overflowing length", "/echo", func(c *testWebSocketClient) {
+			c.writeFrame(false, opBinary, make([]byte, 10), true)
+			c.writeHeader(true, opContinuation, 1<<63-1, true)
+		}, CloseMessageTooBig},
+		{"default limit", "/unlimited", func(c *testWebSocketClient) {
+			c.writeHeader(true, opBinary, 1<<40, true)
+		}, CloseMessageTooBig},
+		{"unmasked", "/echo", func(c *testWebSocketClient) {
+			c.writeFrame(true, opText, []byte("hi"), false)
+		}, CloseProtocolError},
+		{"unexpected continuation", "/echo", func(c *testWebSocketClient) {
+			c.writeFrame(true, opContinuation, []byte("hi"), true)
+		}, CloseProtocolError},
+		{"invalid UTF-8", "/echo", func(c *testWebSocketClient) {
+			c.writeFrame(true, opText, []byte{0xff, 0xfe}, true)
+		}, CloseInvalidPayload},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dialTestWebSocket(t, ts, tc.path)
+			tc.write(c)
+			c.expectClose(tc.code)
+		})
+	}
+}
+
+func TestHubBroadcast(t *testing.T) {
+	hub := NewHub("test")
+	ts := newWebSocketTestServer(t, hub)
+	clients := []*testWebSocketClient{
+		dialTestWebSocket(t, ts, "/hub"),
+		dialTestWebSocket(t, ts, "/hub"),
+	}
+	for i := 0; hub.Count("room") < len(clients); i++ {
+		if i == 100 {
+			t.Fatalf("%d WebSockets joined the hub", hub.Count("room"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := hub.Broadcast("room", TextMessage, []byte("hello all")); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range clients {
+		if opcode, payload := c.readFrame(); opcode != opText || string(payload) != "hello all" {
+			t.Errorf("client %d got opcode %d: %q", i, opcode, payload)
+		}
+	}
+
+	// closed WebSockets leave the hub
+	clients[0].writeFrame(true, opClose, []byte{0x03, 0xe8}, true)
+	clients[0].expectClose(CloseNormal)
+	for i := 0; hub.Count("room") != 1; i++ {
+		if i == 100 {
+			t.Fatalf("%d WebSockets in the hub after one closed", hub.Count("room"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	hub.Broadcast("room", TextMessage, []byte("hello again"))
+	if _, payload := clients[1].readFrame(); string(payload) != "hello again" {
+		t.Errorf("remaining client got %q", payload)
+	}
+}